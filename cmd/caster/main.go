@@ -27,6 +27,8 @@ func main() {
 			commands.Apply,
 			commands.Interpolate,
 			commands.Initialize,
+			commands.List,
+			commands.Cache,
 		},
 	}
 	err := app.Run(os.Args)
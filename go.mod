@@ -3,6 +3,7 @@ module github.com/patrickhuber/caster
 go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/Masterminds/sprig/v3 v3.2.2
 	github.com/google/go-cmp v0.5.9
 	github.com/onsi/ginkgo/v2 v2.9.2
@@ -11,6 +12,7 @@ require (
 	github.com/patrickhuber/go-xplat v0.3.1
 	github.com/stretchr/testify v1.8.4
 	github.com/urfave/cli/v2 v2.23.4
+	golang.org/x/term v0.6.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
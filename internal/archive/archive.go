@@ -0,0 +1,374 @@
+// Package archive reads templates from zip and tar archives into an in memory file system
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"github.com/patrickhuber/caster/internal/cache"
+	"github.com/patrickhuber/go-xplat/filepath"
+	afs "github.com/patrickhuber/go-xplat/fs"
+)
+
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+// Source is the location of an archive, the subdirectory of the template and the expected checksum
+type Source struct {
+	Location string
+	Subdir   string
+	Checksum string
+	Format   string
+}
+
+// IsSource returns true if the template is an http(s) url or a path to a zip, tar or tar.gz archive
+func IsSource(template string) bool {
+	location, _, _ := split(strings.TrimSpace(template))
+	return isURL(location) || len(format(location)) > 0
+}
+
+// Parse parses an archive source of the form <path or url>[//<subdir>][?checksum=sha256:<hex>]
+func Parse(template string) (*Source, error) {
+	template = strings.TrimSpace(template)
+	location, subdir, query := split(template)
+	source := &Source{
+		Location: location,
+		Subdir:   subdir,
+		Format:   format(location),
+	}
+	if len(source.Format) == 0 {
+		return nil, fmt.Errorf("archive '%s' has an unrecognized format. Expected one of .zip, .tar, .tar.gz or .tgz", location)
+	}
+	if len(query) > 0 && !isURL(location) {
+		return nil, fmt.Errorf("archive '%s' has parameters, parameters are only supported for urls", template)
+	}
+	for _, pair := range strings.Split(query, "&") {
+		if len(pair) == 0 {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		switch key {
+		case "checksum":
+			source.Checksum = value
+		default:
+			return nil, fmt.Errorf("archive '%s' has unrecognized parameter '%s'", template, key)
+		}
+	}
+	return source, nil
+}
+
+// split returns the location, the subdirectory after the first double slash following the scheme and the query
+func split(template string) (string, string, string) {
+	query := ""
+	if isURL(template) {
+		if index := strings.Index(template, "?"); index >= 0 {
+			query = template[index+1:]
+			template = template[:index]
+		}
+	}
+	start := 0
+	if index := strings.Index(template, "://"); index >= 0 {
+		start = index + len("://")
+	}
+	subdir := ""
+	if index := strings.Index(template[start:], "//"); index >= 0 {
+		subdir = strings.Trim(template[start+index+len("//"):], "/")
+		template = template[:start+index]
+	}
+	return template, subdir, query
+}
+
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+func format(location string) string {
+	location = strings.ToLower(location)
+	switch {
+	case strings.HasSuffix(location, ".zip"):
+		return FormatZip
+	case strings.HasSuffix(location, ".tar"):
+		return FormatTar
+	case strings.HasSuffix(location, ".tar.gz"), strings.HasSuffix(location, ".tgz"):
+		return FormatTarGz
+	}
+	return ""
+}
+
+// Service opens archives
+type Service interface {
+	// Open reads the archive into an in memory file system and returns the file system and the template directory.
+	// Downloaded archives are cached. When offline is set the most recently downloaded archive in the cache is used.
+	Open(source *Source, offline bool) (afs.FS, string, error)
+}
+
+// NewService creates an archive service that reads local archives from the file system and downloads urls
+func NewService(fs afs.FS, path *filepath.Processor, cache cache.Service) Service {
+	return &service{
+		fs:    fs,
+		path:  path,
+		cache: cache,
+	}
+}
+
+type service struct {
+	fs    afs.FS
+	path  *filepath.Processor
+	cache cache.Service
+}
+
+func (s *service) Open(source *Source, offline bool) (afs.FS, string, error) {
+	content, err := s.read(source, offline)
+	if err != nil {
+		return nil, "", err
+	}
+	err = verify(content, source.Checksum)
+	if err != nil {
+		return nil, "", fmt.Errorf("archive '%s' %w", source.Location, err)
+	}
+
+	// local archives are mounted at their own path and urls at the archive name in the working directory
+	location := source.Location
+	if isURL(location) {
+		location = pathpkg.Base(location)
+	}
+	root, err := s.path.Abs(location)
+	if err != nil {
+		return nil, "", err
+	}
+	memory := afs.NewMemory(afs.WithProcessor(s.path))
+	err = memory.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch source.Format {
+	case FormatZip:
+		err = s.extractZip(memory, root, content)
+	case FormatTar:
+		err = s.extractTar(memory, root, bytes.NewReader(content))
+	case FormatTarGz:
+		var reader *gzip.Reader
+		reader, err = gzip.NewReader(bytes.NewReader(content))
+		if err == nil {
+			err = s.extractTar(memory, root, reader)
+		}
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read archive '%s' : %w", source.Location, err)
+	}
+
+	dir := root
+	if len(source.Subdir) > 0 {
+		dir = s.path.Join(root, source.Subdir)
+	}
+	return memory, dir, nil
+}
+
+func (s *service) read(source *Source, offline bool) ([]byte, error) {
+	location := source.Location
+	if !isURL(location) {
+		abs, err := s.path.Abs(location)
+		if err != nil {
+			return nil, err
+		}
+		return s.fs.ReadFile(abs)
+	}
+
+	// archives with a checksum never change so a cached archive is used without downloading
+	if len(source.Checksum) > 0 {
+		path := s.cache.Path(cache.Key(location, strings.ToLower(source.Checksum)))
+		ok, err := s.fs.Exists(path)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return s.fs.ReadFile(path)
+		}
+	}
+	if offline {
+		entry, ok, err := s.cache.Find(location)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("archive '%s' : %w", location, cache.ErrOffline)
+		}
+		return s.fs.ReadFile(s.cache.Path(entry.Key))
+	}
+
+	content, err := download(location)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	revision := "sha256:" + hex.EncodeToString(sum[:])
+	key := cache.Key(location, revision)
+	path := s.cache.Path(key)
+	err = s.fs.MkdirAll(s.path.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = s.fs.WriteFile(path, content, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return content, s.cache.Add(cache.Entry{
+		Source:   location,
+		Revision: revision,
+		Key:      key,
+		Fetched:  time.Now().UTC(),
+	})
+}
+
+const (
+	// downloadTimeout bounds the whole request including reading the body
+	downloadTimeout = 5 * time.Minute
+	// maxDownloadSize is the largest archive that is read into memory
+	maxDownloadSize = 512 << 20
+)
+
+var client = &http.Client{Timeout: downloadTimeout}
+
+func download(location string) ([]byte, error) {
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download archive '%s' : %s", location, resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxDownloadSize {
+		return nil, fmt.Errorf("unable to download archive '%s' : larger than %d bytes", location, maxDownloadSize)
+	}
+	return content, nil
+}
+
+// verify compares the sha256 checksum of the content with the expected checksum when one is given
+func verify(content []byte, checksum string) error {
+	if len(checksum) == 0 {
+		return nil
+	}
+	algorithm, expected, ok := strings.Cut(checksum, ":")
+	if !ok || algorithm != "sha256" {
+		return fmt.Errorf("has unsupported checksum '%s'. Expected sha256:<hex>", checksum)
+	}
+	sum := sha256.Sum256(content)
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("checksum mismatch, expected sha256:%s found sha256:%s", expected, actual)
+	}
+	return nil
+}
+
+func (s *service) extractZip(memory afs.FS, root string, content []byte) error {
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return err
+	}
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			err = s.writeDir(memory, root, f.Name)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		err = s.writeFile(memory, root, f.Name, data, f.Mode())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) extractTar(memory afs.FS, root string, r io.Reader) error {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = s.writeDir(memory, root, header.Name)
+		case tar.TypeReg:
+			var data []byte
+			data, err = io.ReadAll(reader)
+			if err == nil {
+				err = s.writeFile(memory, root, header.Name, data, header.FileInfo().Mode())
+			}
+		}
+		// links and other entry types are not part of templates
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// join joins the slash separated archive name to the root and rejects names outside of the root
+func (s *service) join(root, name string) (string, error) {
+	clean := pathpkg.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if clean == ".." || strings.HasPrefix(clean, "../") || pathpkg.IsAbs(clean) {
+		return "", fmt.Errorf("entry '%s' is outside of the archive", name)
+	}
+	if clean == "." {
+		return root, nil
+	}
+	return s.path.Join(append([]string{root}, strings.Split(clean, "/")...)...), nil
+}
+
+func (s *service) writeDir(memory afs.FS, root, name string) error {
+	path, err := s.join(root, name)
+	if err != nil {
+		return err
+	}
+	return memory.MkdirAll(path, 0755)
+}
+
+func (s *service) writeFile(memory afs.FS, root, name string, data []byte, mode fs.FileMode) error {
+	path, err := s.join(root, name)
+	if err != nil {
+		return err
+	}
+	err = memory.MkdirAll(s.path.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	if mode.Perm() == 0 {
+		mode = 0644
+	}
+	return memory.WriteFile(path, data, mode.Perm())
+}
@@ -0,0 +1,192 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/patrickhuber/caster/internal/archive"
+	"github.com/patrickhuber/caster/internal/cache"
+	"github.com/patrickhuber/go-xplat/env"
+	"github.com/patrickhuber/go-xplat/filepath"
+	afs "github.com/patrickhuber/go-xplat/fs"
+	"github.com/patrickhuber/go-xplat/os"
+	"github.com/patrickhuber/go-xplat/platform"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	type test struct {
+		name     string
+		template string
+		expected *archive.Source
+	}
+	tests := []test{
+		{"zip", "templates.zip", &archive.Source{Location: "templates.zip", Format: archive.FormatZip}},
+		{"tar", "/srv/templates.tar", &archive.Source{Location: "/srv/templates.tar", Format: archive.FormatTar}},
+		{"tgz", "/srv/templates.tgz//go-cli", &archive.Source{Location: "/srv/templates.tgz", Subdir: "go-cli", Format: archive.FormatTarGz}},
+		{"url", "https://example.com/go-cli.tar.gz?checksum=sha256:abc", &archive.Source{Location: "https://example.com/go-cli.tar.gz", Checksum: "sha256:abc", Format: archive.FormatTarGz}},
+		{"url subdir", "http://example.com/templates.zip//go-cli?checksum=sha256:abc", &archive.Source{Location: "http://example.com/templates.zip", Subdir: "go-cli", Checksum: "sha256:abc", Format: archive.FormatZip}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.True(t, archive.IsSource(test.template))
+			source, err := archive.Parse(test.template)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, source)
+		})
+	}
+	t.Run("not an archive", func(t *testing.T) {
+		require.False(t, archive.IsSource("/srv/templates"))
+		require.False(t, archive.IsSource(".caster.yml"))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, template := range []string{"https://example.com/templates", "https://example.com/go-cli.zip?ref=main"} {
+			_, err := archive.Parse(template)
+			require.Error(t, err, template)
+		}
+	})
+}
+
+func TestOpen(t *testing.T) {
+	files := map[string]string{
+		"go-cli/.caster.yml": "tree: true",
+		"go-cli/main.go":     "package main",
+	}
+	type test struct {
+		name    string
+		archive string
+		content []byte
+	}
+	tests := []test{
+		{"zip", "/templates.zip", Zip(t, files)},
+		{"tar", "/templates.tar", Tar(t, files)},
+		{"tar.gz", "/templates.tar.gz", TarGz(t, files)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.NewProcessorWithOS(os.NewMock(os.WithPlatform(platform.Linux)))
+			fs := afs.NewMemory(afs.WithProcessor(path))
+			require.NoError(t, fs.WriteFile(test.archive, test.content, 0644))
+
+			svc := archive.NewService(fs, path, cache.NewService(fs, env.NewMemory(), os.NewMock(), path))
+			source, err := archive.Parse(test.archive + "//go-cli")
+			require.NoError(t, err)
+			view, dir, err := svc.Open(source, false)
+			require.NoError(t, err)
+			require.Equal(t, test.archive+"/go-cli", dir)
+
+			content, err := view.ReadFile(dir + "/main.go")
+			require.NoError(t, err)
+			require.Equal(t, "package main", string(content))
+
+			// the archive is not unpacked into the file system
+			exists, err := fs.Exists(dir)
+			require.NoError(t, err)
+			require.False(t, exists)
+		})
+	}
+}
+
+func TestOpenURL(t *testing.T) {
+	content := TarGz(t, map[string]string{".caster.yml": "tree: true"})
+	sum := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/go-cli.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	path := filepath.NewProcessorWithOS(os.NewMock(os.WithPlatform(platform.Linux)))
+	fs := afs.NewMemory(afs.WithProcessor(path))
+	c := cache.NewService(fs, env.NewMemory(), os.NewMock(os.WithPlatform(platform.Linux)), path)
+	svc := archive.NewService(fs, path, c)
+	open := func(template string, offline bool) error {
+		source, err := archive.Parse(template)
+		require.NoError(t, err)
+		view, dir, err := svc.Open(source, offline)
+		if err != nil {
+			return err
+		}
+		_, err = view.ReadFile(path.Join(dir, ".caster.yml"))
+		return err
+	}
+	require.NoError(t, open(server.URL+"/go-cli.tar.gz", false))
+	require.NoError(t, open(server.URL+"/go-cli.tar.gz?checksum="+checksum, false))
+	require.ErrorContains(t, open(server.URL+"/go-cli.tar.gz?checksum=sha256:0000", false), "checksum mismatch")
+	require.ErrorContains(t, open(server.URL+"/missing.tar.gz", false), "404")
+
+	entries, err := c.List()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries))
+	require.Equal(t, checksum, entries[0].Revision)
+
+	// downloaded archives are read from the cache when offline or pinned by checksum
+	server.Close()
+	require.NoError(t, open(server.URL+"/go-cli.tar.gz", true))
+	require.NoError(t, open(server.URL+"/go-cli.tar.gz?checksum="+checksum, false))
+	require.ErrorIs(t, open(server.URL+"/missing.tar.gz", true), cache.ErrOffline)
+}
+
+func TestOpenOutsideArchive(t *testing.T) {
+	path := filepath.NewProcessorWithOS(os.NewMock(os.WithPlatform(platform.Linux)))
+	fs := afs.NewMemory(afs.WithProcessor(path))
+	require.NoError(t, fs.WriteFile("/templates.tar", Tar(t, map[string]string{"../escape.txt": "escape"}), 0644))
+
+	_, _, err := archive.NewService(fs, path, cache.NewService(fs, env.NewMemory(), os.NewMock(), path)).Open(&archive.Source{Location: "/templates.tar", Format: archive.FormatTar}, false)
+	require.ErrorContains(t, err, "outside of the archive")
+}
+
+func names(files map[string]string) []string {
+	var result []string
+	for name := range files {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func Zip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names(files) {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func Tar(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, name := range names(files) {
+		require.NoError(t, w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}))
+		_, err := w.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(Tar(t, files))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
@@ -0,0 +1,201 @@
+// Package cache stores fetched templates by source and resolved revision
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/patrickhuber/go-xplat/env"
+	"github.com/patrickhuber/go-xplat/filepath"
+	afs "github.com/patrickhuber/go-xplat/fs"
+	"github.com/patrickhuber/go-xplat/os"
+)
+
+const (
+	// CacheHomeEnv is the environment variable for the user cache directory
+	CacheHomeEnv = "XDG_CACHE_HOME"
+	// IndexFile is the name of the file listing the cache entries
+	IndexFile = "index.json"
+)
+
+// ErrOffline is returned when a template is not in the cache and fetching is not allowed
+var ErrOffline = errors.New("template is not in the cache and fetching is disabled with --offline")
+
+// Entry is a fetched revision of a template source
+type Entry struct {
+	// Source identifies what was fetched, for example a git url and ref or an archive url
+	Source string `yaml:"source" json:"source"`
+	// Revision is the resolved commit of a git source or the sha256 checksum of an archive
+	Revision string `yaml:"revision" json:"revision"`
+	// Key is the name of the entry in the cache directory
+	Key     string    `yaml:"key" json:"key"`
+	Fetched time.Time `yaml:"fetched" json:"fetched"`
+}
+
+// Service manages the template cache
+type Service interface {
+	// Dir returns the cache directory
+	Dir() string
+	// Path returns the path of the cache entry with the key
+	Path(key string) string
+	// Find returns the most recently fetched entry of the source
+	Find(source string) (*Entry, bool, error)
+	// Add records the entry replacing an entry with the same source and revision
+	Add(entry Entry) error
+	// List returns the entries sorted by source and most recently fetched first
+	List() ([]Entry, error)
+	// Prune removes every entry except the most recent entry of each source and returns the removed entries
+	Prune() ([]Entry, error)
+	// Clear removes the cache directory
+	Clear() error
+}
+
+// NewService creates a new instance of the cache service
+func NewService(fs afs.FS, e env.Environment, o os.OS, path *filepath.Processor) Service {
+	return &service{
+		fs:   fs,
+		env:  e,
+		os:   o,
+		path: path,
+	}
+}
+
+type service struct {
+	fs   afs.FS
+	env  env.Environment
+	os   os.OS
+	path *filepath.Processor
+}
+
+// Key returns the content address of the source revision
+func Key(source, revision string) string {
+	sum := sha256.Sum256([]byte(source + "@" + revision))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Dir returns XDG_CACHE_HOME/caster when set, otherwise the .cache/caster directory in the user's home directory
+func (s *service) Dir() string {
+	cacheHome := strings.TrimSpace(s.env.Get(CacheHomeEnv))
+	if len(cacheHome) == 0 {
+		cacheHome = s.path.Join(s.os.Home(), ".cache")
+	}
+	return s.path.Join(cacheHome, "caster")
+}
+
+func (s *service) Path(key string) string {
+	return s.path.Join(s.Dir(), "templates", key)
+}
+
+func (s *service) Find(source string) (*Entry, bool, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, entry := range entries {
+		if entry.Source == source {
+			return &entry, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (s *service) Add(entry Entry) error {
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+	var result []Entry
+	for _, e := range entries {
+		if e.Source == entry.Source && e.Revision == entry.Revision {
+			continue
+		}
+		result = append(result, e)
+	}
+	return s.write(append(result, entry))
+}
+
+func (s *service) List() ([]Entry, error) {
+	entries, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Source != entries[j].Source {
+			return entries[i].Source < entries[j].Source
+		}
+		return entries[i].Fetched.After(entries[j].Fetched)
+	})
+	return entries, nil
+}
+
+func (s *service) Prune() ([]Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var kept, removed []Entry
+	used := map[string]struct{}{}
+	for _, entry := range entries {
+		if len(kept) > 0 && kept[len(kept)-1].Source == entry.Source {
+			removed = append(removed, entry)
+			continue
+		}
+		kept = append(kept, entry)
+		used[entry.Key] = struct{}{}
+	}
+
+	// entries shared by a kept source stay in the cache
+	for _, entry := range removed {
+		if _, ok := used[entry.Key]; ok {
+			continue
+		}
+		err = s.fs.RemoveAll(s.Path(entry.Key))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return removed, s.write(kept)
+}
+
+func (s *service) Clear() error {
+	ok, err := s.fs.Exists(s.Dir())
+	if err != nil || !ok {
+		return err
+	}
+	return s.fs.RemoveAll(s.Dir())
+}
+
+func (s *service) read() ([]Entry, error) {
+	content, err := s.fs.ReadFile(s.path.Join(s.Dir(), IndexFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	err = json.Unmarshal(content, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cache index : %w", err)
+	}
+	return entries, nil
+}
+
+func (s *service) write(entries []Entry) error {
+	err := s.fs.MkdirAll(s.Dir(), 0755)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.fs.WriteFile(s.path.Join(s.Dir(), IndexFile), content, 0644)
+}
@@ -0,0 +1,98 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/patrickhuber/caster/internal/cache"
+	"github.com/patrickhuber/go-xplat/env"
+	"github.com/patrickhuber/go-xplat/filepath"
+	afs "github.com/patrickhuber/go-xplat/fs"
+	"github.com/patrickhuber/go-xplat/os"
+	"github.com/patrickhuber/go-xplat/platform"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService(t *testing.T) {
+	setup := func(t *testing.T) (cache.Service, afs.FS) {
+		o := os.NewMock(os.WithPlatform(platform.Linux))
+		path := filepath.NewProcessorWithOS(o)
+		fs := afs.NewMemory(afs.WithProcessor(path))
+		return cache.NewService(fs, env.NewMemory(), o, path), fs
+	}
+	add := func(t *testing.T, svc cache.Service, fs afs.FS, source, revision string, fetched time.Time) cache.Entry {
+		entry := cache.Entry{Source: source, Revision: revision, Key: cache.Key(source, revision), Fetched: fetched}
+		require.NoError(t, fs.MkdirAll(svc.Path(entry.Key), 0755))
+		require.NoError(t, svc.Add(entry))
+		return entry
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("dir", func(t *testing.T) {
+		svc, _ := setup(t)
+		require.Equal(t, "/home/fake/.cache/caster", svc.Dir())
+		require.Equal(t, "/home/fake/.cache/caster/templates/abc", svc.Path("abc"))
+	})
+	t.Run("xdg cache home", func(t *testing.T) {
+		o := os.NewMock(os.WithPlatform(platform.Linux))
+		path := filepath.NewProcessorWithOS(o)
+		e := env.NewMemory()
+		require.NoError(t, e.Set("XDG_CACHE_HOME", "/cache"))
+		svc := cache.NewService(afs.NewMemory(afs.WithProcessor(path)), e, o, path)
+		require.Equal(t, "/cache/caster", svc.Dir())
+	})
+	t.Run("find returns the most recent revision", func(t *testing.T) {
+		svc, fs := setup(t)
+		add(t, svc, fs, "a", "1", now)
+		latest := add(t, svc, fs, "a", "2", now.Add(time.Hour))
+		add(t, svc, fs, "b", "1", now.Add(2*time.Hour))
+
+		entry, ok, err := svc.Find("a")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, latest, *entry)
+
+		_, ok, err = svc.Find("c")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+	t.Run("add replaces the same revision", func(t *testing.T) {
+		svc, fs := setup(t)
+		add(t, svc, fs, "a", "1", now)
+		add(t, svc, fs, "a", "1", now.Add(time.Hour))
+		entries, err := svc.List()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(entries))
+		require.Equal(t, now.Add(time.Hour), entries[0].Fetched)
+	})
+	t.Run("prune keeps the most recent revision of each source", func(t *testing.T) {
+		svc, fs := setup(t)
+		old := add(t, svc, fs, "a", "1", now)
+		latest := add(t, svc, fs, "a", "2", now.Add(time.Hour))
+		other := add(t, svc, fs, "b", "1", now)
+
+		removed, err := svc.Prune()
+		require.NoError(t, err)
+		require.Equal(t, []cache.Entry{old}, removed)
+
+		entries, err := svc.List()
+		require.NoError(t, err)
+		require.Equal(t, []cache.Entry{latest, other}, entries)
+
+		exists, err := fs.Exists(svc.Path(old.Key))
+		require.NoError(t, err)
+		require.False(t, exists)
+		exists, err = fs.Exists(svc.Path(latest.Key))
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+	t.Run("clear", func(t *testing.T) {
+		svc, fs := setup(t)
+		add(t, svc, fs, "a", "1", now)
+		require.NoError(t, svc.Clear())
+		entries, err := svc.List()
+		require.NoError(t, err)
+		require.Empty(t, entries)
+		require.NoError(t, svc.Clear())
+	})
+}
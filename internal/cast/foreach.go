@@ -0,0 +1,95 @@
+package cast
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/patrickhuber/caster/internal/interpolate"
+	"github.com/patrickhuber/caster/internal/models"
+)
+
+// DefaultForeachAlias is the name of the variable holding the current foreach item
+const DefaultForeachAlias = models.DefaultForeachAlias
+
+// expand returns a planner for each item of the foreach expression with the item added to the data.
+// The planner itself is returned when the expression is empty.
+func (p *planner) expand(name, foreach, as string) ([]*planner, error) {
+	if len(strings.TrimSpace(foreach)) == 0 {
+		return []*planner{p}, nil
+	}
+	if len(as) == 0 {
+		as = DefaultForeachAlias
+	}
+	value, err := evaluateValue(foreach, p.funcs, p.data)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' : unable to evaluate foreach '%s' : %w", name, foreach, err)
+	}
+	items, err := toList(value)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' : foreach '%s' %w", name, foreach, err)
+	}
+	var planners []*planner
+	for _, item := range items {
+		q := *p
+		q.data = mergeData(p.data, map[string]any{as: item})
+		planners = append(planners, &q)
+	}
+	return planners, nil
+}
+
+// evaluateValue returns the value of the template pipeline
+func evaluateValue(expr string, funcs template.FuncMap, data any) (any, error) {
+	expr = strings.TrimSpace(expr)
+	var value any
+	capture := template.FuncMap{}
+	for k, v := range funcs {
+		capture[k] = v
+	}
+	capture["__capture"] = func(v any) string {
+		value = v
+		return ""
+	}
+	_, err := renderTemplate("foreach", []byte("{{ __capture ("+expr+") }}"), capture, data)
+	return value, err
+}
+
+// toList converts a slice or array to a list. A nil value is an empty list.
+func toList(value any) ([]any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("must be a list, found '%v'", value)
+	}
+	var items []any
+	for i := 0; i < v.Len(); i++ {
+		items = append(items, v.Index(i).Interface())
+	}
+	return items, nil
+}
+
+// renderFile renders the actions of the file that were deferred until the foreach item is known
+func (p *planner) renderFile(file models.File) (models.File, error) {
+	err := p.renderDeferred(file.Name, &file.Name, &file.Content, &file.Ref, &file.Link, &file.Mode)
+	return file, err
+}
+
+// renderFolder renders the actions of the folder that were deferred until the foreach item is known
+func (p *planner) renderFolder(folder models.Folder) (models.Folder, error) {
+	err := p.renderDeferred(folder.Name, &folder.Name, &folder.Ref, &folder.Mode)
+	return folder, err
+}
+
+func (p *planner) renderDeferred(name string, fields ...*string) error {
+	for _, field := range fields {
+		rendered, err := interpolate.RenderDeferred(*field, p.funcs, p.data)
+		if err != nil {
+			return fmt.Errorf("'%s' : %w", name, err)
+		}
+		*field = rendered
+	}
+	return nil
+}
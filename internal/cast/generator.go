@@ -2,85 +2,285 @@ package cast
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 	"text/template"
-	"text/template/parse"
+	"unicode/utf8"
 
+	"github.com/patrickhuber/caster/internal/models"
 	"github.com/patrickhuber/go-xplat/filepath"
 	afs "github.com/patrickhuber/go-xplat/fs"
 )
 
+// generator walks a template directory and creates the caster model for it.
+// When render is set, file and folder names are rendered as templates. A name with an unclosed
+// block action like '{{ if .enabled }}name' is closed automatically and the entry is skipped when
+// the name renders empty.
 type generator struct {
-	fs   afs.FS
-	path filepath.Processor
+	fs     afs.FS
+	path   *filepath.Processor
+	funcs  template.FuncMap
+	data   map[string]interface{}
+	render bool
+	// skip contains absolute paths that are never walked
+	skip []string
+	// include contains glob patterns for files relative to the root. An empty list includes all files.
+	include []string
+	// exclude contains glob patterns for files and folders relative to the root
+	exclude []string
 }
 
-func (g *generator) Generate(root string, data map[string]interface{}) (string, error) {
-	var buffer bytes.Buffer
-	err := g.build(root, &buffer, 0)
+// Generate walks the root directory and returns the rendered files and folders
+func (g *generator) Generate(root string) (*models.Caster, error) {
+	files, folders, err := g.build(root, root)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	content := buffer.String()
-	tpl, err := template.New("").Parse(content)
+	return &models.Caster{
+		Files:   files,
+		Folders: folders,
+	}, nil
+}
+
+func (g *generator) build(root, dir string) ([]models.File, []models.Folder, error) {
+	infos, err := readDir(g.fs, g.path, dir)
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+
+	var files []models.File
+	var folders []models.Folder
+
+	for _, info := range infos {
+		path := g.path.Join(dir, info.Name())
+		if g.skipped(path) || ignored(info) {
+			continue
+		}
+
+		rel, err := g.path.Rel(root, path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		slashRel := strings.ReplaceAll(rel, string(g.path.Separator), "/")
+		excluded, err := matchAny(g.exclude, slashRel)
+		if err != nil {
+			return nil, nil, err
+		}
+		if excluded {
+			continue
+		}
+
+		name, err := g.renderName(rel, info.Name())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// names that render empty are skipped along with their children
+		if len(strings.TrimSpace(name)) == 0 {
+			continue
+		}
+
+		if info.IsDir() {
+			childFiles, childFolders, err := g.build(root, path)
+			if err != nil {
+				return nil, nil, err
+			}
+			// folders without included files are dropped when filtering
+			if len(g.include) > 0 && len(childFiles) == 0 && len(childFolders) == 0 {
+				continue
+			}
+			folders = append(folders, models.Folder{
+				Name:    name,
+				Files:   childFiles,
+				Folders: childFolders,
+			})
+			continue
+		}
+
+		if len(g.include) > 0 {
+			included, err := matchAny(g.include, slashRel)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !included {
+				continue
+			}
+		}
+
+		content, err := g.fs.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		content, err = g.renderContent(rel, content)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, models.File{
+			Name:    name,
+			Content: string(content),
+			Mode:    fmt.Sprintf("%04o", info.Mode().Perm()),
+		})
+	}
+	return files, folders, nil
+}
+
+// vcsDirs are version control directories that are never part of a template.
+// A .git file is also skipped because worktrees and submodules use one to point at the repository.
+var vcsDirs = map[string]bool{".git": true, ".hg": true, ".svn": true, ".bzr": true}
+
+// ignored returns true for version control directories and caster files, caster files of the template
+// and of templates nested in it describe how to render the directory and are not rendered themselves
+func ignored(info fs.FileInfo) bool {
+	if vcsDirs[info.Name()] {
+		return true
+	}
+	return !info.IsDir() && strings.HasPrefix(info.Name(), ".caster.")
+}
+
+func (g *generator) skipped(path string) bool {
+	for _, skip := range g.skip {
+		if path == skip {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) renderName(rel, name string) (string, error) {
+	if !g.render || !strings.Contains(name, "{{") {
+		return name, nil
+	}
+
+	tpl, err := template.New(rel).Funcs(g.funcs).Parse(name)
+	if err != nil {
+		// check if appending {{end}} resolves the error
+		// if it doesn't, return the original error
+		var endErr error
+		tpl, endErr = template.New(rel).Funcs(g.funcs).Parse(name + "{{end}}")
+		if endErr != nil {
+			return "", err
+		}
 	}
 
 	var result bytes.Buffer
-	err = tpl.Execute(&result, data)
+	err = tpl.Execute(&result, g.data)
 	if err != nil {
 		return "", err
 	}
 	return result.String(), nil
 }
 
-func (g *generator) build(root string, buffer io.Writer, level int) error {
-	info, err := g.fs.Stat(root)
-	if err != nil {
-		return err
+func (g *generator) renderContent(rel string, content []byte) ([]byte, error) {
+	// binary files and files without actions are copied as is
+	if !g.render || !utf8.Valid(content) || !bytes.Contains(content, []byte("{{")) {
+		return content, nil
 	}
-	name := info.Name()
 
-	if level > 0 {
-		fmt.Fprintln(buffer)
-	}
-	fmt.Fprintf(buffer, "%s%s", strings.Repeat("\t", level), name)
-	if info.IsDir() {
-		fmt.Fprintf(buffer, "/")
+	return renderTemplate(rel, content, g.funcs, g.data)
+}
+
+// renderTemplate renders the content as a template with the function map and data
+func renderTemplate(name string, content []byte, funcs template.FuncMap, data any) ([]byte, error) {
+	tpl, err := template.New(name).Funcs(funcs).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template file '%s' : %w", name, err)
 	}
 
-	files, err := g.fs.ReadDir(root)
+	var result bytes.Buffer
+	err = tpl.Execute(&result, data)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, file := range files {
-		err = g.build(g.path.Join(root, file.Name()), buffer, level+1)
+	return result.Bytes(), nil
+}
+
+// matchAny returns true if the slash separated path or its base name matches any of the glob patterns.
+// Patterns support '*', '?' and character classes like path.Match and '**' to match any number of folders.
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, pattern := range patterns {
+		ok, err := match(pattern, name)
 		if err != nil {
-			return err
+			return false, err
+		}
+		if ok {
+			return true, nil
 		}
 	}
+	return false, nil
+}
 
-	if !strings.Contains(name, "{{") {
-		return nil
+func match(pattern, name string) (bool, error) {
+	if !strings.Contains(pattern, "**") {
+		ok, err := path.Match(pattern, name)
+		if err != nil || ok {
+			return ok, err
+		}
+		return path.Match(pattern, path.Base(name))
 	}
 
-	_, err = parse.Parse("caster", name, "{{", "}}")
-	if err == nil {
-		return nil
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
 	}
-	// check if printing {{end}} resolves the error
-	// if it doesn't, return the error
-	_, err = parse.Parse("caster", name+"{{end}}", "{{", "}}")
+	expr.WriteString("$")
+	reg, err := regexp.Compile(expr.String())
 	if err != nil {
-		return err
+		return false, err
 	}
-	_, err = fmt.Fprintln(buffer)
+	return reg.MatchString(name), nil
+}
+
+// readDir returns the direct children of the directory sorted by name.
+// Some file system implementations return nested entries as well, so each entry is
+// verified to exist directly under the directory.
+func readDir(fsys afs.FS, path *filepath.Processor, dir string) ([]fs.FileInfo, error) {
+	entries, err := fsys.ReadDir(dir)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = fmt.Fprintf(buffer, "%s%s", strings.Repeat("\t", level), "{{end}}")
-	return err
+
+	seen := map[string]struct{}{}
+	var infos []fs.FileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		info, err := fsys.Stat(path.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
 }
@@ -0,0 +1,135 @@
+package cast
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/patrickhuber/caster/internal/execute"
+	"github.com/patrickhuber/caster/internal/models"
+)
+
+const (
+	StagePreApply  = "pre_apply"
+	StagePostApply = "post_apply"
+)
+
+// HookStatus is the result of a hook
+type HookStatus string
+
+const (
+	HookPlanned HookStatus = "planned"
+	HookRan     HookStatus = "ran"
+	HookSkipped HookStatus = "skipped"
+)
+
+// Hook is a hook planned or run for the target
+type Hook struct {
+	Stage   string     `yaml:"stage" json:"stage"`
+	Command string     `yaml:"command" json:"command"`
+	Dir     string     `yaml:"dir" json:"dir"`
+	Status  HookStatus `yaml:"status" json:"status"`
+	Reason  string     `yaml:"reason,omitempty" json:"reason,omitempty"`
+	env     map[string]string
+}
+
+// planHooks evaluates the when condition of the hooks and resolves the working directories.
+// Hooks that are not allowed are skipped unless they are declared by a caster file in a trusted directory.
+func (p *planner) planHooks(stage string, hooks []models.Hook, allowed bool) ([]Hook, error) {
+	var planned []Hook
+	for _, hook := range hooks {
+		q := p.withScope(hook.Scope)
+		// variables sharing the name of a foreach alias are deferred in hooks too
+		err := q.renderDeferred(hook.Command, &hook.Command, &hook.Dir)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := p.hookDir(hook.Dir)
+		if err != nil {
+			return nil, err
+		}
+		h := Hook{
+			Stage:   stage,
+			Command: hook.Command,
+			Dir:     dir,
+			Status:  HookPlanned,
+			env:     hook.Env,
+		}
+		ok, err := evaluate(hook.When, q.funcs, q.data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to evaluate when '%s' of %s hook '%s' : %w", hook.When, stage, hook.Command, err)
+		}
+		switch {
+		case len(strings.TrimSpace(hook.Command)) == 0:
+			return nil, fmt.Errorf("%s hook is missing a command", stage)
+		case !ok:
+			h.Status = HookSkipped
+			h.Reason = fmt.Sprintf("when '%s' is false", hook.When)
+		case !allowed && !q.isTrusted():
+			h.Status = HookSkipped
+			h.Reason = "hooks are not allowed for this template, use --allow-hooks to run them"
+		}
+		planned = append(planned, h)
+	}
+	return planned, nil
+}
+
+// isTrusted returns true if the caster file of the planner is inside one of the trusted directories
+func (p *planner) isTrusted() bool {
+	for _, dir := range p.trusted {
+		abs, err := p.path.Abs(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := p.path.Rel(abs, p.sourceFile)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(p.path.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hookDir returns the absolute working directory of the hook. The directory must be inside the target.
+func (p *planner) hookDir(dir string) (string, error) {
+	if len(dir) == 0 {
+		return p.target, nil
+	}
+	parsed, err := p.path.Parser.Parse(dir)
+	if err != nil {
+		return "", err
+	}
+	if parsed.IsAbs() {
+		return "", fmt.Errorf("hook dir '%s' must be relative to the target", dir)
+	}
+	abs := p.path.Join(p.target, dir)
+	rel, err := p.path.Rel(p.target, abs)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(p.path.Separator)) {
+		return "", fmt.Errorf("hook dir '%s' is outside of the target", dir)
+	}
+	return abs, nil
+}
+
+// runHooks runs the planned hooks of the stage and marks them as ran
+func (s *service) runHooks(stage string, hooks []Hook) error {
+	for i := range hooks {
+		hook := &hooks[i]
+		if hook.Stage != stage || hook.Status != HookPlanned {
+			continue
+		}
+		err := s.executor.Run(&execute.Command{
+			Script: hook.Command,
+			Dir:    hook.Dir,
+			Env:    hook.env,
+		})
+		if err != nil {
+			return fmt.Errorf("%s hook '%s' failed : %w", stage, hook.Command, err)
+		}
+		hook.Status = HookRan
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package cast
+
+import "io/fs"
+
+// Response is the response object for casting a template
+type Response struct {
+	Target  string  `yaml:"target" json:"target"`
+	Entries []Entry `yaml:"entries,omitempty" json:"entries,omitempty"`
+	Hooks   []Hook  `yaml:"hooks,omitempty" json:"hooks,omitempty"`
+}
+
+// Kind is the type of entry in the target
+type Kind string
+
+const (
+	KindFile   Kind = "file"
+	KindFolder Kind = "folder"
+	KindLink   Kind = "link"
+)
+
+// Action is the change made to an entry in the target
+type Action string
+
+const (
+	ActionCreate    Action = "create"
+	ActionOverwrite Action = "overwrite"
+	ActionUnchanged Action = "unchanged"
+	ActionSkip      Action = "skip"
+	ActionBackup    Action = "backup"
+	// ActionPrompt is only planned in a dry run, otherwise the user is asked to overwrite or skip
+	ActionPrompt Action = "prompt"
+)
+
+// Entry is a file or folder planned for the target
+type Entry struct {
+	Name    string      `yaml:"name" json:"name"`
+	Path    string      `yaml:"path" json:"path"`
+	Kind    Kind        `yaml:"kind" json:"kind"`
+	Action  Action      `yaml:"action" json:"action"`
+	Size    int64       `yaml:"size" json:"size"`
+	Link    string      `yaml:"link,omitempty" json:"link,omitempty"`
+	Mode    fs.FileMode `yaml:"-" json:"-"`
+	Entries []Entry     `yaml:"entries,omitempty" json:"entries,omitempty"`
+	Content []byte      `yaml:"-" json:"-"`
+	// Reason explains why the entry is skipped
+	Reason string `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
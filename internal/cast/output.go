@@ -0,0 +1,211 @@
+package cast
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+const (
+	// OutputDir writes the entries into the target directory
+	OutputDir = "dir"
+	// OutputZip writes the entries to a zip archive
+	OutputZip = "zip"
+	// OutputTar writes the entries to a tar archive
+	OutputTar = "tar"
+	// OutputTarGz writes the entries to a gzip compressed tar archive
+	OutputTarGz = "tar.gz"
+)
+
+// validateOutputFormat returns an error if the output format is not recognized
+func validateOutputFormat(format string) error {
+	switch format {
+	case OutputDir, OutputZip, OutputTar, OutputTarGz:
+		return nil
+	}
+	return fmt.Errorf("unrecognized output format '%s'. Expected one of dir, zip, tar or tar.gz", format)
+}
+
+// archiveWriter writes entries to an archive
+type archiveWriter interface {
+	writeFolder(name string, mode fs.FileMode) error
+	writeFile(name string, content []byte, mode fs.FileMode) error
+	writeLink(name, link string) error
+	Close() error
+}
+
+// checkArchiveTarget returns an error when the archive target is an existing directory
+func (s *service) checkArchiveTarget(target, format string) error {
+	info, err := s.fs.Stat(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("target '%s' is a directory. The %s output format writes a file, expected a file path or '-' for stdout", target, format)
+	}
+	return nil
+}
+
+// writeArchive writes the entries to the output. When the output is nil the archive is written to the target file.
+func (s *service) writeArchive(req *Request, entries []Entry) error {
+	output := req.Output
+	if output == nil {
+		err := s.fs.MkdirAll(s.path.Dir(req.Target), DefaultFolderMode)
+		if err != nil {
+			return err
+		}
+		file, err := s.fs.Create(req.Target)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+
+	var writer archiveWriter
+	modified := time.Now()
+	switch req.OutputFormat {
+	case OutputZip:
+		writer = &zipWriter{writer: zip.NewWriter(output), modified: modified}
+	case OutputTar:
+		writer = &tarWriter{writer: tar.NewWriter(output), modified: modified}
+	case OutputTarGz:
+		compressed := gzip.NewWriter(output)
+		writer = &tarWriter{writer: tar.NewWriter(compressed), compressed: compressed, modified: modified}
+	default:
+		return validateOutputFormat(req.OutputFormat)
+	}
+
+	err := writeArchiveEntries(writer, entries, umask())
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// writeArchiveEntries writes the entries with the umask applied to their modes, the same modes the
+// entries would have when written to a directory
+func writeArchiveEntries(writer archiveWriter, entries []Entry, mask fs.FileMode) error {
+	for _, entry := range entries {
+		if entry.Action == ActionSkip {
+			continue
+		}
+		// archive names always use forward slashes
+		name := strings.ReplaceAll(entry.Path, "\\", "/")
+		var err error
+		switch entry.Kind {
+		case KindFolder:
+			err = writer.writeFolder(name, entry.Mode&^mask)
+			if err == nil {
+				err = writeArchiveEntries(writer, entry.Entries, mask)
+			}
+		case KindFile:
+			err = writer.writeFile(name, entry.Content, entry.Mode&^mask)
+		case KindLink:
+			err = writer.writeLink(name, strings.ReplaceAll(entry.Link, "\\", "/"))
+		default:
+			err = fmt.Errorf("unrecognized entry kind '%s'", entry.Kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type zipWriter struct {
+	writer   *zip.Writer
+	modified time.Time
+}
+
+func (w *zipWriter) writeFolder(name string, mode fs.FileMode) error {
+	header := &zip.FileHeader{Name: name + "/", Modified: w.modified}
+	header.SetMode(fs.ModeDir | mode.Perm())
+	_, err := w.writer.CreateHeader(header)
+	return err
+}
+
+func (w *zipWriter) writeFile(name string, content []byte, mode fs.FileMode) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: w.modified}
+	header.SetMode(mode.Perm())
+	writer, err := w.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(content)
+	return err
+}
+
+// writeLink writes a symbolic link the way zip tools do, as an entry with the link mode and the link as content
+func (w *zipWriter) writeLink(name, link string) error {
+	header := &zip.FileHeader{Name: name, Modified: w.modified}
+	header.SetMode(fs.ModeSymlink | 0777)
+	writer, err := w.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(writer, link)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.writer.Close()
+}
+
+type tarWriter struct {
+	writer     *tar.Writer
+	compressed *gzip.Writer
+	modified   time.Time
+}
+
+func (w *tarWriter) writeFolder(name string, mode fs.FileMode) error {
+	return w.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     int64(mode.Perm()),
+		ModTime:  w.modified,
+	})
+}
+
+func (w *tarWriter) writeFile(name string, content []byte, mode fs.FileMode) error {
+	err := w.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     int64(len(content)),
+		ModTime:  w.modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Write(content)
+	return err
+}
+
+func (w *tarWriter) writeLink(name, link string) error {
+	return w.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: link,
+		Mode:     0777,
+		ModTime:  w.modified,
+	})
+}
+
+func (w *tarWriter) Close() error {
+	err := w.writer.Close()
+	if err != nil || w.compressed == nil {
+		return err
+	}
+	return w.compressed.Close()
+}
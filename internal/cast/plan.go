@@ -0,0 +1,485 @@
+package cast
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/patrickhuber/caster/internal/models"
+	"github.com/patrickhuber/caster/internal/prompt"
+	"github.com/patrickhuber/caster/internal/symlink"
+	"github.com/patrickhuber/go-xplat/filepath"
+	afs "github.com/patrickhuber/go-xplat/fs"
+)
+
+const (
+	// ConflictOverwrite replaces the existing file
+	ConflictOverwrite = "overwrite"
+	// ConflictSkip keeps the existing file
+	ConflictSkip = "skip"
+	// ConflictFail stops the cast before anything is written
+	ConflictFail = "fail"
+	// ConflictBackup renames the existing file with a .bak suffix before writing
+	ConflictBackup = "backup"
+	// ConflictPrompt asks the user to overwrite the existing file
+	ConflictPrompt = "prompt"
+)
+
+const (
+	// DefaultFileMode is the mode of created files before the umask is applied
+	DefaultFileMode fs.FileMode = 0666
+	// DefaultFolderMode is the mode of created folders before the umask is applied
+	DefaultFolderMode fs.FileMode = 0777
+)
+
+// parseMode parses an octal permission mode like "0755", "755" or "0o755".
+// An empty mode returns the default mode.
+func parseMode(mode string, defaultMode fs.FileMode) (fs.FileMode, error) {
+	mode = strings.TrimSpace(mode)
+	if len(mode) == 0 {
+		return defaultMode, nil
+	}
+	value, err := strconv.ParseUint(strings.TrimPrefix(mode, "0o"), 8, 32)
+	if err != nil || value > uint64(fs.ModePerm) {
+		return 0, fmt.Errorf("invalid mode '%s'. Expected an octal permission like 0644", mode)
+	}
+	return fs.FileMode(value), nil
+}
+
+// validateConflictPolicy returns an error if the conflict policy is not recognized
+func validateConflictPolicy(onConflict string) error {
+	switch onConflict {
+	case ConflictOverwrite, ConflictSkip, ConflictFail, ConflictBackup, ConflictPrompt:
+		return nil
+	}
+	return fmt.Errorf("unrecognized conflict policy '%s'. Expected one of overwrite, skip, fail, backup or prompt", onConflict)
+}
+
+// planner creates the target entries for a caster file without writing to the target
+type planner struct {
+	fs afs.FS
+	// sourceFS is the file system refs are read from
+	sourceFS   afs.FS
+	path       *filepath.Processor
+	prompt     prompt.Service
+	linker     symlink.Linker
+	source     string
+	sourceFile string
+	target     string
+	funcs      template.FuncMap
+	data       map[string]any
+	onConflict string
+	dryRun     bool
+	// interactive is set when conflicts can be resolved by asking the user
+	interactive bool
+	// empty is set when the target is written to an archive. Every entry is then created.
+	empty bool
+	// umask is the file mode creation mask applied to the modes of written files
+	umask fs.FileMode
+	// trusted contains the directories of templates allowed to run hooks
+	trusted []string
+}
+
+// plan creates the entries for the files and folders.
+// The rel path is the path of the files and folders relative to the target.
+func (p *planner) plan(rel string, files []models.File, folders []models.Folder) ([]Entry, error) {
+	var entries []Entry
+	for i := range files {
+		planners, err := p.withScope(files[i].Scope).expand(files[i].Name, files[i].Foreach, files[i].As)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range planners {
+			file, err := q.renderFile(files[i])
+			if err != nil {
+				return nil, err
+			}
+			path := p.path.Join(rel, file.Name)
+			kind := KindFile
+			if len(file.Link) > 0 {
+				kind = KindLink
+			}
+			entry, err := q.planWhen(file.Name, path, kind, file.When)
+			if err == nil && entry == nil {
+				entry, err = q.planFile(&file, path)
+			}
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, *entry)
+		}
+	}
+	for i := range folders {
+		planners, err := p.withScope(folders[i].Scope).expand(folders[i].Name, folders[i].Foreach, folders[i].As)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range planners {
+			folder, err := q.renderFolder(folders[i])
+			if err != nil {
+				return nil, err
+			}
+			// included and extended templates without a target subpath are planned into the parent
+			if folder.Scope != nil && len(folder.Name) == 0 {
+				children, err := q.planChildren(&folder, rel)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, children...)
+				continue
+			}
+			path := p.path.Join(rel, folder.Name)
+			entry, err := q.planWhen(folder.Name, path, KindFolder, folder.When)
+			if err == nil && entry == nil {
+				entry, err = q.planFolder(&folder, path)
+			}
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+// planWhen returns a skipped entry when the when expression is false and nil when it is true
+func (p *planner) planWhen(name, rel string, kind Kind, when string) (*Entry, error) {
+	ok, err := evaluate(when, p.funcs, p.data)
+	if err != nil {
+		return nil, fmt.Errorf("%s '%s' : unable to evaluate when '%s' : %w", kind, rel, when, err)
+	}
+	if ok {
+		return nil, nil
+	}
+	return &Entry{
+		Name:   name,
+		Path:   rel,
+		Kind:   kind,
+		Action: ActionSkip,
+		Reason: fmt.Sprintf("when '%s' is false", when),
+	}, nil
+}
+
+func (p *planner) planFolder(folder *models.Folder, rel string) (*Entry, error) {
+	mode, err := parseMode(folder.Mode, DefaultFolderMode)
+	if err != nil {
+		return nil, fmt.Errorf("folder '%s' : %w", rel, err)
+	}
+
+	action := ActionCreate
+	ok, err := p.exists(p.path.Join(p.target, rel))
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		action = ActionUnchanged
+	}
+
+	entries, err := p.planChildren(folder, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{
+		Name:    folder.Name,
+		Path:    rel,
+		Kind:    KindFolder,
+		Action:  action,
+		Mode:    mode,
+		Entries: entries,
+	}, nil
+}
+
+// planChildren plans the files and folders of the folder including the files and folders copied from the ref
+func (p *planner) planChildren(folder *models.Folder, rel string) ([]Entry, error) {
+	files, folders := folder.Files, folder.Folders
+	if len(folder.Ref) > 0 {
+		var err error
+		files, folders, err = p.copyFolder(folder)
+		if err != nil {
+			return nil, fmt.Errorf("folder '%s' : %w", rel, err)
+		}
+	}
+	return p.plan(rel, files, folders)
+}
+
+// withScope returns a planner for the source, data and functions of an included template.
+// The planner itself is returned when the scope is nil.
+func (p *planner) withScope(scope *models.Scope) *planner {
+	if scope == nil {
+		return p
+	}
+	q := *p
+	q.source = p.path.Dir(scope.SourceFile)
+	q.sourceFile = scope.SourceFile
+	q.data = scope.Data
+	q.funcs = scope.Funcs
+	return &q
+}
+
+// copyFolder walks the folder ref and returns the copied files and folders followed by the
+// files and folders of the folder
+func (p *planner) copyFolder(folder *models.Folder) ([]models.File, []models.Folder, error) {
+	root := p.path.Join(p.source, folder.Ref)
+	info, err := p.sourceFS.Stat(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		return nil, nil, fmt.Errorf("ref '%s' is not a folder", folder.Ref)
+	}
+
+	g := &generator{
+		fs:      p.sourceFS,
+		path:    p.path,
+		funcs:   p.funcs,
+		data:    p.data,
+		render:  folder.Template,
+		skip:    []string{p.sourceFile, p.target},
+		include: folder.Include,
+		exclude: folder.Exclude,
+	}
+	copied, err := g.Generate(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(copied.Files, folder.Files...), append(copied.Folders, folder.Folders...), nil
+}
+
+func (p *planner) planFile(file *models.File, rel string) (*Entry, error) {
+	if len(file.Link) > 0 {
+		return p.planLink(file, rel)
+	}
+
+	content := []byte(file.Content)
+	mode := DefaultFileMode
+	explicitMode := false
+
+	// is the ref set and the content empty?
+	if file.Content == "" && file.Ref != "" {
+		path := p.path.Join(p.source, file.Ref)
+		info, err := p.sourceFS.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		content, err = p.sourceFS.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if file.Template {
+			content, err = renderTemplate(file.Ref, content, p.funcs, mergeData(p.data, file.Data))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// keep the mode of the referenced file. It is not compared to existing files because the source
+		// mode depends on how the template was checked out.
+		mode = info.Mode().Perm()
+	}
+
+	if len(file.Mode) > 0 {
+		var err error
+		mode, err = parseMode(file.Mode, mode)
+		if err != nil {
+			return nil, fmt.Errorf("file '%s' : %w", rel, err)
+		}
+		explicitMode = true
+	}
+
+	action, err := p.fileAction(p.path.Join(p.target, rel), content, mode, explicitMode)
+	if err != nil {
+		return nil, err
+	}
+
+	action, err = p.resolveFileConflict(file, rel, action)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{
+		Name:    file.Name,
+		Path:    rel,
+		Kind:    KindFile,
+		Action:  action,
+		Size:    int64(len(content)),
+		Mode:    mode,
+		Content: content,
+		Reason:  conflictReason(action),
+	}, nil
+}
+
+// planLink plans a symbolic link. The link path is relative to the directory containing the link
+// and must resolve to a path inside the target.
+func (p *planner) planLink(file *models.File, rel string) (*Entry, error) {
+	if len(file.Content) > 0 || len(file.Ref) > 0 {
+		return nil, fmt.Errorf("file '%s' can not set link with content or ref", rel)
+	}
+
+	path := p.path.Join(p.target, rel)
+	link, err := p.path.Parser.Parse(file.Link)
+	if err != nil {
+		return nil, err
+	}
+	if link.IsAbs() {
+		return nil, fmt.Errorf("link '%s' for file '%s' must be a relative path", file.Link, rel)
+	}
+	resolved := p.path.Join(p.path.Dir(path), file.Link)
+	resolvedRel, err := p.path.Rel(p.target, resolved)
+	if err != nil {
+		return nil, err
+	}
+	if resolvedRel == ".." || strings.HasPrefix(resolvedRel, ".."+string(p.path.Separator)) {
+		return nil, fmt.Errorf("link '%s' for file '%s' escapes the target '%s'", file.Link, rel, p.target)
+	}
+
+	action, err := p.linkAction(path, file.Link)
+	if err != nil {
+		return nil, err
+	}
+
+	action, err = p.resolveFileConflict(file, rel, action)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{
+		Name:   file.Name,
+		Path:   rel,
+		Kind:   KindLink,
+		Action: action,
+		Link:   file.Link,
+		Reason: conflictReason(action),
+	}, nil
+}
+
+// conflictReason returns the reason for an existing file that is skipped by the conflict policy
+func conflictReason(action Action) string {
+	if action == ActionSkip {
+		return "the existing file is kept"
+	}
+	return ""
+}
+
+// linkAction compares the link to the existing link or file to determine the action
+func (p *planner) linkAction(path, link string) (Action, error) {
+	if p.empty {
+		return ActionCreate, nil
+	}
+	existing, err := p.linker.Readlink(path)
+	if err == nil {
+		if existing == link {
+			return ActionUnchanged, nil
+		}
+		return ActionOverwrite, nil
+	}
+	ok, err := p.exists(path)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return ActionOverwrite, nil
+	}
+	return ActionCreate, nil
+}
+
+// exists returns true if the path exists in the target
+func (p *planner) exists(path string) (bool, error) {
+	if p.empty {
+		return false, nil
+	}
+	return p.fs.Exists(path)
+}
+
+// mergeData returns a copy of the data with the keys of the override replacing existing keys
+func mergeData(data, override map[string]any) map[string]any {
+	if len(override) == 0 {
+		return data
+	}
+	merged := map[string]any{}
+	for k, v := range data {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// resolveFileConflict applies the conflict policy of the file when the action is an overwrite
+func (p *planner) resolveFileConflict(file *models.File, rel string, action Action) (Action, error) {
+	if action != ActionOverwrite {
+		return action, nil
+	}
+	onConflict := file.OnConflict
+	if len(onConflict) == 0 {
+		onConflict = p.onConflict
+	}
+	return p.resolveConflict(rel, onConflict)
+}
+
+// fileAction compares the content and explicit mode to the existing file to determine the action
+func (p *planner) fileAction(path string, content []byte, mode fs.FileMode, explicitMode bool) (Action, error) {
+	if p.empty {
+		return ActionCreate, nil
+	}
+	info, err := p.fs.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ActionCreate, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	existing, err := p.fs.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(existing, content) {
+		return ActionOverwrite, nil
+	}
+	if explicitMode && !p.sameMode(info.Mode().Perm(), mode) {
+		return ActionOverwrite, nil
+	}
+	return ActionUnchanged, nil
+}
+
+// sameMode returns true if the existing mode is the requested mode with or without the umask applied
+func (p *planner) sameMode(existing, mode fs.FileMode) bool {
+	return existing == mode || existing == mode&^p.umask
+}
+
+// resolveConflict applies the conflict policy to an existing file with different content.
+// Conflicts are resolved before anything is written so a failure leaves the target untouched.
+func (p *planner) resolveConflict(rel, onConflict string) (Action, error) {
+	switch onConflict {
+	case ConflictOverwrite:
+		return ActionOverwrite, nil
+	case ConflictSkip:
+		return ActionSkip, nil
+	case ConflictBackup:
+		return ActionBackup, nil
+	case ConflictFail:
+		return "", fmt.Errorf("file '%s' already exists in target '%s'", rel, p.target)
+	case ConflictPrompt:
+		if p.dryRun {
+			return ActionPrompt, nil
+		}
+		if !p.interactive {
+			return "", fmt.Errorf("file '%s' has conflict policy '%s' which requires an interactive console", rel, ConflictPrompt)
+		}
+		ok, err := p.prompt.Confirm(fmt.Sprintf("overwrite '%s'?", rel), false)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return ActionOverwrite, nil
+		}
+		return ActionSkip, nil
+	}
+	return "", validateConflictPolicy(onConflict)
+}
@@ -1,8 +1,14 @@
 package cast
 
 import (
+	"fmt"
+	"io"
+
+	"github.com/patrickhuber/caster/internal/execute"
 	"github.com/patrickhuber/caster/internal/interpolate"
 	"github.com/patrickhuber/caster/internal/models"
+	"github.com/patrickhuber/caster/internal/prompt"
+	"github.com/patrickhuber/caster/internal/symlink"
 	"github.com/patrickhuber/go-xplat/filepath"
 	afs "github.com/patrickhuber/go-xplat/fs"
 )
@@ -12,46 +18,110 @@ type Request struct {
 	Template  string
 	Target    string
 	Variables []models.Variable
+	// DryRun plans the changes to the target without writing them
+	DryRun bool
+	// OnConflict is the default policy for files that already exist in the target
+	OnConflict string
+	// Interactive prompts for declared variables that are missing a value
+	Interactive bool
+	// SaveVariables is the path of a variable file where prompted answers are saved
+	SaveVariables string
+	// ListMerge is the strategy for merging lists from multiple variable sources
+	ListMerge string
+	// ListMergeKey is the key used to match list items with the merge strategy
+	ListMergeKey string
+	// EnvPrefix is the prefix trimmed from environment variable names
+	EnvPrefix string
+	// AllowHooks runs the pre and post apply hooks of the template
+	AllowHooks bool
+	// TrustedDirs contains the directories of trusted templates. Hooks declared by a caster file
+	// inside one of them run without AllowHooks.
+	TrustedDirs []string
+	// Offline reads git and archive templates from the cache and fails instead of fetching them
+	Offline bool
+	// OutputFormat writes the entries into the target directory or to an archive (dir|zip|tar|tar.gz). Defaults to dir.
+	OutputFormat string
+	// Output is the writer archives are written to. When nil the archive is written to the target path.
+	Output io.Writer
 }
 
 // Service handles casting of a template
 type Service interface {
-	Cast(req *Request) error
+	Cast(req *Request) (*Response, error)
 }
 
 type service struct {
-	fs    afs.FS
-	path  *filepath.Processor
-	inter interpolate.Service
+	fs       afs.FS
+	path     *filepath.Processor
+	inter    interpolate.Service
+	prompt   prompt.Service
+	linker   symlink.Linker
+	executor execute.Executor
 }
 
 // NewService creates a new instance of the cast service
-func NewService(fs afs.FS, inter interpolate.Service, path *filepath.Processor) Service {
+func NewService(fs afs.FS, inter interpolate.Service, path *filepath.Processor, prompt prompt.Service, linker symlink.Linker, executor execute.Executor) Service {
 	return &service{
-		fs:    fs,
-		inter: inter,
-		path:  path,
+		fs:       fs,
+		inter:    inter,
+		path:     path,
+		prompt:   prompt,
+		linker:   linker,
+		executor: executor,
 	}
 }
 
-func (s *service) Cast(req *Request) error {
+func (s *service) Cast(req *Request) (*Response, error) {
+
+	if len(req.OnConflict) == 0 {
+		req.OnConflict = ConflictOverwrite
+	}
+	err := validateConflictPolicy(req.OnConflict)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.OutputFormat) == 0 {
+		req.OutputFormat = OutputDir
+	}
+	err = validateOutputFormat(req.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	archived := req.OutputFormat != OutputDir
+
+	// conflicts only need an answer when files are written to the target directory
+	if req.OnConflict == ConflictPrompt && !req.Interactive && !req.DryRun && !archived {
+		return nil, fmt.Errorf("conflict policy '%s' requires an interactive console", ConflictPrompt)
+	}
 
 	variables := []models.Variable{}
 	for _, v := range req.Variables {
 		variables = append(variables, models.Variable{
-			Env:   v.Env,
-			File:  v.File,
-			Key:   v.Key,
-			Value: v.Value,
+			Env:    v.Env,
+			File:   v.File,
+			Key:    v.Key,
+			Value:  v.Value,
+			Format: v.Format,
 		})
 	}
+	// a dry run does not write anything, including the prompted answers
+	saveVariables := req.SaveVariables
+	if req.DryRun {
+		saveVariables = ""
+	}
 	resp, err := s.inter.Interpolate(&interpolate.Request{
-		Template:  req.Template,
-		Variables: variables,
+		Template:      req.Template,
+		Variables:     variables,
+		Interactive:   req.Interactive,
+		SaveVariables: saveVariables,
+		ListMerge:     req.ListMerge,
+		ListMergeKey:  req.ListMergeKey,
+		EnvPrefix:     req.EnvPrefix,
+		Offline:       req.Offline,
 	})
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// if no target directory specified, use the local directory
@@ -62,66 +132,137 @@ func (s *service) Cast(req *Request) error {
 	// resolve relative paths
 	req.Target, err = s.path.Abs(req.Target)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// archives are written to a file, the default target '.' and other directories can not be created as one
+	if archived && req.Output == nil && !req.DryRun {
+		err = s.checkArchiveTarget(req.Target, req.OutputFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// templates read from archives are read from the archive file system
+	sourceFS := resp.FS
+	if sourceFS == nil {
+		sourceFS = s.fs
 	}
 
 	source := s.path.Dir(resp.SourceFile)
-	return s.executeCasterFile(&resp.Caster, source, req.Target)
-}
+	caster := &resp.Caster
+	if caster.Tree {
+		// archives are not written into the target directory so the template directory can be the target
+		if source == req.Target && !archived {
+			return nil, fmt.Errorf("unable to render template directory '%s' as a tree into itself", source)
+		}
+		caster, err = s.generateTree(resp, sourceFS, source, req.Target)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-func (s *service) executeCasterFile(caster *models.Caster, source, target string) error {
-	err := s.castFiles(source, target, source, caster.Files)
+	p := &planner{
+		fs:          s.fs,
+		sourceFS:    sourceFS,
+		path:        s.path,
+		prompt:      s.prompt,
+		linker:      s.linker,
+		source:      source,
+		sourceFile:  resp.SourceFile,
+		target:      req.Target,
+		funcs:       resp.Funcs,
+		data:        resp.Data,
+		onConflict:  req.OnConflict,
+		dryRun:      req.DryRun,
+		interactive: req.Interactive,
+		empty:       archived,
+		umask:       umask(),
+		trusted:     req.TrustedDirs,
+	}
+	entries, err := p.plan("", caster.Files, caster.Folders)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	ok, err := s.fs.Exists(target)
+
+	preApply, err := p.planHooks(StagePreApply, resp.Caster.Hooks.PreApply, req.AllowHooks)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if !ok {
-		err := s.fs.Mkdir(target, 0600)
-		if err != nil {
-			return err
-		}
+	postApply, err := p.planHooks(StagePostApply, resp.Caster.Hooks.PostApply, req.AllowHooks)
+	if err != nil {
+		return nil, err
 	}
-	return s.castFolders(source, target, source, caster.Folders)
-}
 
-func (s *service) castFolders(source, target, path string, folders []models.Folder) error {
-	for _, folder := range folders {
-		sourcePath := s.path.Join(path, folder.Name)
-		err := s.castFolder(&folder, source, target, sourcePath)
-		if err != nil {
-			return err
+	response := &Response{
+		Target:  req.Target,
+		Entries: entries,
+		Hooks:   append(preApply, postApply...),
+	}
+
+	// hooks run in the target directory so they can not run when the target is an archive
+	if archived {
+		for i := range response.Hooks {
+			if response.Hooks[i].Status == HookPlanned {
+				response.Hooks[i].Status = HookSkipped
+				response.Hooks[i].Reason = fmt.Sprintf("hooks do not run with the %s output format", req.OutputFormat)
+			}
 		}
 	}
-	return nil
-}
+	if req.DryRun {
+		return response, nil
+	}
+	if archived {
+		return response, s.writeArchive(req, entries)
+	}
 
-func (s *service) castFolder(folder *models.Folder, source, target, path string) error {
-	rel, err := s.path.Rel(source, path)
+	err = s.createTarget(req.Target)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	targetPath := s.path.Join(target, rel)
-	err = s.fs.Mkdir(targetPath, 0600)
+	err = s.runHooks(StagePreApply, response.Hooks)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	err = s.executeEntries(req.Target, entries)
+	if err != nil {
+		return nil, err
 	}
+	return response, s.runHooks(StagePostApply, response.Hooks)
+}
 
-	err = s.castFiles(source, target, path, folder.Files)
+// generateTree walks the template directory and appends the explicit files and folders
+// of the caster file to the generated files and folders
+func (s *service) generateTree(resp *interpolate.Response, sourceFS afs.FS, source, target string) (*models.Caster, error) {
+	g := &generator{
+		fs:     sourceFS,
+		path:   s.path,
+		funcs:  resp.Funcs,
+		data:   resp.Data,
+		render: true,
+		skip:   []string{resp.SourceFile, target},
+	}
+	caster, err := g.Generate(source)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	caster.Files = append(caster.Files, resp.Caster.Files...)
+	caster.Folders = append(caster.Folders, resp.Caster.Folders...)
+	return caster, nil
+}
 
-	return s.castFolders(source, target, path, folder.Folders)
+// createTarget creates the target directory if it does not exist
+func (s *service) createTarget(target string) error {
+	ok, err := s.fs.Exists(target)
+	if err != nil || ok {
+		return err
+	}
+	return s.fs.Mkdir(target, DefaultFolderMode)
 }
 
-func (s *service) castFiles(source, target string, path string, files []models.File) error {
-	for _, file := range files {
-		sourcePath := s.path.Join(path, file.Name)
-		err := s.castFile(&file, source, target, sourcePath)
+func (s *service) executeEntries(target string, entries []Entry) error {
+	for _, entry := range entries {
+		err := s.executeEntry(target, &entry)
 		if err != nil {
 			return err
 		}
@@ -129,21 +270,56 @@ func (s *service) castFiles(source, target string, path string, files []models.F
 	return nil
 }
 
-func (s *service) castFile(file *models.File, source, target, path string) error {
-	rel, err := s.path.Rel(source, path)
-	if err != nil {
-		return err
+func (s *service) executeEntry(target string, entry *Entry) error {
+	path := s.path.Join(target, entry.Path)
+	switch entry.Kind {
+	case KindFolder:
+		if entry.Action == ActionCreate {
+			err := s.fs.MkdirAll(path, entry.Mode)
+			if err != nil {
+				return err
+			}
+		}
+		return s.executeEntries(target, entry.Entries)
+	case KindFile, KindLink:
+		switch entry.Action {
+		case ActionUnchanged, ActionSkip:
+			return nil
+		case ActionBackup:
+			backup, err := s.backupPath(path)
+			if err != nil {
+				return err
+			}
+			err = s.fs.Rename(path, backup)
+			if err != nil {
+				return err
+			}
+		case ActionOverwrite:
+			// remove the existing file so the mode is applied to the new file
+			err := s.fs.Remove(path)
+			if err != nil {
+				return err
+			}
+		}
+		if entry.Kind == KindLink {
+			return s.linker.Symlink(entry.Link, path)
+		}
+		return s.fs.WriteFile(path, entry.Content, entry.Mode)
 	}
-	targetPath := s.path.Join(target, rel)
-	content := []byte(file.Content)
+	return fmt.Errorf("unrecognized entry kind '%s'", entry.Kind)
+}
 
-	// is the ref set and the content empty?
-	if file.Content == "" && file.Ref != "" {
-		path := s.path.Join(source, file.Ref)
-		content, err = s.fs.ReadFile(path)
+// backupPath returns the first path with a .bak suffix that does not exist
+func (s *service) backupPath(path string) (string, error) {
+	backup := path + ".bak"
+	for i := 1; ; i++ {
+		ok, err := s.fs.Exists(backup)
 		if err != nil {
-			return err
+			return "", err
+		}
+		if !ok {
+			return backup, nil
 		}
+		backup = fmt.Sprintf("%s.bak.%d", path, i)
 	}
-	return s.fs.WriteFile(targetPath, content, 0600)
 }
@@ -1,15 +1,31 @@
 package cast_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"runtime"
 	"strings"
 	"testing"
 
+	"github.com/patrickhuber/caster/internal/archive"
+	"github.com/patrickhuber/caster/internal/cache"
 	"github.com/patrickhuber/caster/internal/cast"
+	"github.com/patrickhuber/caster/internal/execute"
+	"github.com/patrickhuber/caster/internal/git"
 	"github.com/patrickhuber/caster/internal/interpolate"
 	"github.com/patrickhuber/caster/internal/models"
+	"github.com/patrickhuber/caster/internal/prompt"
+	"github.com/patrickhuber/caster/internal/symlink"
 	"github.com/stretchr/testify/require"
 
 	"github.com/patrickhuber/go-xplat/arch"
+	"github.com/patrickhuber/go-xplat/console"
+	afs "github.com/patrickhuber/go-xplat/fs"
 	"github.com/patrickhuber/go-xplat/host"
 	"github.com/patrickhuber/go-xplat/platform"
 )
@@ -46,14 +62,27 @@ func Setup(t *testing.T, h *host.Host, content []byte, inter interpolate.Service
 	require.NoError(t, err)
 	require.True(t, sourceInfo.IsDir())
 
-	svc := cast.NewService(h.FS, inter, h.Path)
+	svc := cast.NewService(h.FS, inter, h.Path, prompt.NewService(h.Console), symlink.NewMemory(h.FS), execute.NewMemory())
 
-	err = svc.Cast(request)
+	_, err = svc.Cast(request)
 	require.NoError(t, err)
 
 	AssertExists(t, h, request.Target)
 }
 
+// NewInterpolateService creates an interpolate service for the host that records git commands instead of running them
+func NewInterpolateService(h *host.Host) interpolate.Service {
+	c := cache.NewService(h.FS, h.Env, h.OS, h.Path)
+	return interpolate.NewService(h.FS, h.Env, h.Path, prompt.NewService(h.Console),
+		git.NewService(h.FS, c, h.Path, execute.NewMemory()),
+		archive.NewService(h.FS, h.Path, c))
+}
+
+// NewCastService creates a cast service for the host that runs hooks with the executor
+func NewCastService(h *host.Host, executor execute.Executor) cast.Service {
+	return cast.NewService(h.FS, NewInterpolateService(h), h.Path, prompt.NewService(h.Console), symlink.NewMemory(h.FS), executor)
+}
+
 func AssertExists(t *testing.T, h *host.Host, path string) {
 	ok, err := h.FS.Exists(path)
 	require.NoError(t, err)
@@ -66,6 +95,21 @@ func AssertContents(t *testing.T, h *host.Host, path, content string) {
 	require.Equal(t, content, string(data))
 }
 
+// WriteFiles creates the parent folders and writes each file
+func WriteFiles(h *host.Host, files map[string]string) error {
+	for path, content := range files {
+		err := h.FS.MkdirAll(h.Path.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		err = h.FS.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func TestService(t *testing.T) {
 	type file struct {
 		path    string
@@ -218,6 +262,163 @@ files:
 			},
 			nil,
 		},
+		{
+			"tree",
+			`---
+tree: true
+files:
+- name: extra.txt
+  content: extra`,
+			[]file{
+				{"/output/value.txt", "value", false},
+				{"/output/sub", "", true},
+				{"/output/sub/static.bin", "\xff{{ .key }}", false},
+				{"/output/extra.txt", "extra", false},
+			},
+			&cast.Request{
+				Template: "/template",
+				Target:   "/output",
+				Variables: []models.Variable{
+					{Key: "key", Value: "value"},
+				},
+			},
+			func(h *host.Host) error {
+				err := h.FS.WriteFile("/template/{{ .key }}.txt", []byte("{{ .key }}"), 0666)
+				if err != nil {
+					return err
+				}
+				err = h.FS.MkdirAll("/template/sub", 0666)
+				if err != nil {
+					return err
+				}
+				return h.FS.WriteFile("/template/sub/static.bin", []byte("\xff{{ .key }}"), 0666)
+			},
+		},
+		{
+			"tree_implicit",
+			``,
+			[]file{
+				{"/output/docs", "", true},
+				{"/output/docs/readme.md", "# value", false},
+			},
+			&cast.Request{
+				Template: "/template",
+				Target:   "/output",
+				Variables: []models.Variable{
+					{Key: "key", Value: "value"},
+				},
+			},
+			func(h *host.Host) error {
+				err := h.FS.MkdirAll("/template/{{ if .key }}docs", 0666)
+				if err != nil {
+					return err
+				}
+				err = h.FS.WriteFile("/template/{{ if .key }}docs/readme.md", []byte("# {{ .key }}"), 0666)
+				if err != nil {
+					return err
+				}
+				err = h.FS.MkdirAll("/template/{{ if .missing }}skipped", 0666)
+				if err != nil {
+					return err
+				}
+				return h.FS.WriteFile("/template/{{ if .missing }}skipped/file.txt", []byte("skipped"), 0666)
+			},
+		},
+		{
+			"folder_ref",
+			`---
+folders:
+- name: assets
+  ref: static
+  exclude:
+  - "*.tmp"
+  - "**/cache/**"
+  files:
+  - name: extra.txt
+    content: extra`,
+			[]file{
+				{"/output/assets/logo.png", "\x89PNG", false},
+				{"/output/assets/{{ .key }}.txt", "{{ .key }}", false},
+				{"/output/assets/fonts/font.woff", "font", false},
+				{"/output/assets/extra.txt", "extra", false},
+			},
+			&cast.Request{
+				Template: "/template",
+				Target:   "/output",
+				Variables: []models.Variable{
+					{Key: "key", Value: "value"},
+				},
+			},
+			func(h *host.Host) error {
+				return WriteFiles(h, map[string]string{
+					"/template/static/logo.png":             "\x89PNG",
+					"/template/static/{{ .key }}.txt":       "{{ .key }}",
+					"/template/static/skip.tmp":             "skip",
+					"/template/static/fonts/font.woff":      "font",
+					"/template/static/fonts/cache/font.bin": "cache",
+				})
+			},
+		},
+		{
+			"folder_ref_template",
+			`---
+folders:
+- name: docs
+  ref: docs
+  template: true
+  include:
+  - "*.md"`,
+			[]file{
+				{"/output/docs/value.md", "# value", false},
+				{"/output/docs/nested/readme.md", "value", false},
+			},
+			&cast.Request{
+				Template: "/template",
+				Target:   "/output",
+				Variables: []models.Variable{
+					{Key: "key", Value: "value"},
+				},
+			},
+			func(h *host.Host) error {
+				return WriteFiles(h, map[string]string{
+					"/template/docs/{{ .key }}.md":    "# {{ .key }}",
+					"/template/docs/skip.txt":         "skip",
+					"/template/docs/nested/readme.md": "{{ .key }}",
+					"/template/docs/other/skip.txt":   "skip",
+				})
+			},
+		},
+		{
+			"ref_template",
+			`---
+files:
+- name: main.go
+  ref: main.go.tpl
+  template: true
+- name: other.go
+  ref: main.go.tpl
+  template: true
+  data:
+    package: other`,
+			[]file{
+				{"/output/main.go", "package main\n\n// module github.com/test", false},
+				{"/output/other.go", "package other\n\n// module github.com/test", false},
+			},
+			&cast.Request{
+				Template: "/template",
+				Target:   "/output",
+				Variables: []models.Variable{
+					{Key: "package", Value: "main"},
+					{Key: "module", Value: "github.com/test"},
+				},
+			},
+			func(h *host.Host) error {
+				return WriteFiles(h, map[string]string{
+					"/template/main.go.tpl": "package {{ .package }}\n\n{{ templatefile \"module.tpl\" . }}",
+					"/template/module.tpl":  "// module {{ .module }}",
+				})
+			},
+		},
 		{
 			"env",
 			`---
@@ -245,7 +446,7 @@ files:
 		t.Run(test.name, func(t *testing.T) {
 			h := host.NewTest(platform.Linux, arch.AMD64)
 			h.OS.ChangeDirectory("/")
-			svc := interpolate.NewService(h.FS, h.Env, h.Path)
+			svc := NewInterpolateService(h)
 			if test.hostFunc != nil {
 				require.NoError(t, test.hostFunc(h))
 			}
@@ -259,3 +460,955 @@ files:
 		})
 	}
 }
+
+func TestDryRun(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, h.FS.MkdirAll("/template", 0600))
+	require.NoError(t, h.FS.MkdirAll("/output/sub", 0600))
+	require.NoError(t, h.FS.WriteFile("/output/same.txt", []byte("same"), 0600))
+	require.NoError(t, h.FS.WriteFile("/output/sub/changed.txt", []byte("old"), 0600))
+
+	template := `files:
+- name: same.txt
+  content: same
+- name: new.txt
+  content: new
+folders:
+- name: sub
+  files:
+  - name: changed.txt
+    content: new`
+	require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+	svc := NewCastService(h, execute.NewMemory())
+	resp, err := svc.Cast(&cast.Request{
+		Template: "/template",
+		Target:   "/output",
+		DryRun:   true,
+	})
+	require.NoError(t, err)
+
+	type entry struct {
+		path   string
+		action cast.Action
+		size   int64
+	}
+	want := []entry{
+		{"same.txt", cast.ActionUnchanged, 4},
+		{"new.txt", cast.ActionCreate, 3},
+		{"sub", cast.ActionUnchanged, 0},
+	}
+	require.Equal(t, len(want), len(resp.Entries))
+	for i, w := range want {
+		require.Equal(t, w.path, resp.Entries[i].Path)
+		require.Equal(t, w.action, resp.Entries[i].Action)
+		require.Equal(t, w.size, resp.Entries[i].Size)
+	}
+	require.Equal(t, 1, len(resp.Entries[2].Entries))
+	require.Equal(t, cast.ActionOverwrite, resp.Entries[2].Entries[0].Action)
+
+	// nothing is written
+	ok, err := h.FS.Exists("/output/new.txt")
+	require.NoError(t, err)
+	require.False(t, ok)
+	AssertContents(t, h, "/output/sub/changed.txt", "old")
+}
+
+func TestConflict(t *testing.T) {
+	type test struct {
+		name       string
+		onConflict string
+		template   string
+		input      string
+		want       map[string]string
+		err        bool
+	}
+	template := `files:
+- name: test.txt
+  content: new`
+	tests := []test{
+		{"default", "", template, "", map[string]string{"/output/test.txt": "new"}, false},
+		{"overwrite", cast.ConflictOverwrite, template, "", map[string]string{"/output/test.txt": "new"}, false},
+		{"skip", cast.ConflictSkip, template, "", map[string]string{"/output/test.txt": "old"}, false},
+		{"fail", cast.ConflictFail, template, "", map[string]string{"/output/test.txt": "old"}, true},
+		{"backup", cast.ConflictBackup, template, "", map[string]string{
+			"/output/test.txt":     "new",
+			"/output/test.txt.bak": "old",
+		}, false},
+		{"prompt_yes", cast.ConflictPrompt, template, "y\n", map[string]string{"/output/test.txt": "new"}, false},
+		{"prompt_no", cast.ConflictPrompt, template, "n\n", map[string]string{"/output/test.txt": "old"}, false},
+		{"prompt_default", cast.ConflictPrompt, template, "", map[string]string{"/output/test.txt": "old"}, false},
+		{"file_override", cast.ConflictFail, template + "\n  on_conflict: skip", "", map[string]string{"/output/test.txt": "old"}, false},
+		{"unrecognized", "replace", template, "", map[string]string{"/output/test.txt": "old"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := host.NewTest(platform.Linux, arch.AMD64)
+			h.OS.ChangeDirectory("/")
+			require.NoError(t, h.FS.MkdirAll("/template", 0600))
+			require.NoError(t, h.FS.MkdirAll("/output", 0600))
+			require.NoError(t, h.FS.WriteFile("/output/test.txt", []byte("old"), 0600))
+			require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte(test.template), 0600))
+
+			con, ok := h.Console.(console.Memory)
+			require.True(t, ok)
+			con.InBuffer().WriteString(test.input)
+
+			svc := NewCastService(h, execute.NewMemory())
+			_, err := svc.Cast(&cast.Request{
+				Template:    "/template",
+				Target:      "/output",
+				OnConflict:  test.onConflict,
+				Interactive: true,
+			})
+			if test.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			for path, content := range test.want {
+				AssertContents(t, h, path, content)
+			}
+		})
+	}
+
+	t.Run("prompt_non_interactive", func(t *testing.T) {
+		h := host.NewTest(platform.Linux, arch.AMD64)
+		h.OS.ChangeDirectory("/")
+		require.NoError(t, h.FS.MkdirAll("/template", 0600))
+		require.NoError(t, h.FS.MkdirAll("/output", 0600))
+		require.NoError(t, h.FS.WriteFile("/output/test.txt", []byte("old"), 0600))
+		require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+		svc := NewCastService(h, execute.NewMemory())
+		_, err := svc.Cast(&cast.Request{
+			Template:   "/template",
+			Target:     "/output",
+			OnConflict: cast.ConflictPrompt,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "requires an interactive console")
+		AssertContents(t, h, "/output/test.txt", "old")
+	})
+	t.Run("file_prompt_non_interactive", func(t *testing.T) {
+		h := host.NewTest(platform.Linux, arch.AMD64)
+		h.OS.ChangeDirectory("/")
+		require.NoError(t, h.FS.MkdirAll("/template", 0600))
+		require.NoError(t, h.FS.MkdirAll("/output", 0600))
+		require.NoError(t, h.FS.WriteFile("/output/test.txt", []byte("old"), 0600))
+		require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte(template+"\n  on_conflict: prompt"), 0600))
+
+		svc := NewCastService(h, execute.NewMemory())
+		_, err := svc.Cast(&cast.Request{
+			Template:   "/template",
+			Target:     "/output",
+			OnConflict: cast.ConflictSkip,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "requires an interactive console")
+		AssertContents(t, h, "/output/test.txt", "old")
+	})
+}
+
+func TestMode(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, h.FS.MkdirAll("/template", 0755))
+	require.NoError(t, h.FS.WriteFile("/template/run.sh", []byte("#!/bin/sh"), 0755))
+	require.NoError(t, h.FS.WriteFile("/output/existing.sh", []byte("echo"), 0644))
+
+	template := `files:
+- name: default.txt
+  content: default
+- name: script.sh
+  mode: "0755"
+  content: "#!/bin/sh"
+- name: existing.sh
+  mode: "0700"
+  content: "echo"
+- name: ref.sh
+  ref: run.sh
+- name: ref_override.sh
+  ref: run.sh
+  mode: "0750"
+folders:
+- name: default
+- name: private
+  mode: "0700"`
+	require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+	svc := NewCastService(h, execute.NewMemory())
+	_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output"})
+	require.NoError(t, err)
+
+	want := map[string]fs.FileMode{
+		"/output/default.txt":     cast.DefaultFileMode,
+		"/output/script.sh":       0755,
+		"/output/existing.sh":     0700,
+		"/output/ref.sh":          0755,
+		"/output/ref_override.sh": 0750,
+		"/output/default":         cast.DefaultFolderMode,
+		"/output/private":         0700,
+	}
+	for path, mode := range want {
+		info, err := h.FS.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, mode, info.Mode().Perm(), path)
+	}
+}
+
+func TestModeReapply(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on windows")
+	}
+	// the os file system applies the umask so the written modes differ from the requested modes
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.FS = afs.NewOS()
+	dir := t.TempDir()
+	require.NoError(t, h.FS.MkdirAll(dir+"/template", 0755))
+	require.NoError(t, h.FS.WriteFile(dir+"/template/run.sh", []byte("#!/bin/sh"), 0666))
+	template := `files:
+- name: script.sh
+  mode: "0777"
+  content: "#!/bin/sh"
+- name: ref.sh
+  ref: run.sh`
+	require.NoError(t, h.FS.WriteFile(dir+"/template/.caster.yml", []byte(template), 0600))
+
+	svc := NewCastService(h, execute.NewMemory())
+	request := &cast.Request{Template: dir + "/template", Target: dir + "/output", OnConflict: cast.ConflictFail}
+	_, err := svc.Cast(request)
+	require.NoError(t, err)
+
+	// unchanged files are not conflicts when the template is applied again
+	_, err = svc.Cast(request)
+	require.NoError(t, err)
+}
+
+func TestInvalidMode(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, h.FS.MkdirAll("/template", 0755))
+	require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte("files:\n- name: test.txt\n  mode: \"0999\""), 0600))
+
+	svc := NewCastService(h, execute.NewMemory())
+	_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output"})
+	require.Error(t, err)
+}
+
+func TestLink(t *testing.T) {
+	type test struct {
+		name     string
+		template string
+		want     map[string]string
+		err      bool
+	}
+	tests := []test{
+		{"link", `files:
+- name: AGENTS.md
+  content: agents
+- name: CLAUDE.md
+  link: AGENTS.md
+folders:
+- name: sub
+  files:
+  - name: config.yml
+    link: ../shared/config.yml`, map[string]string{
+			"/output/CLAUDE.md":      "AGENTS.md",
+			"/output/sub/config.yml": "../shared/config.yml",
+		}, false},
+		{"escapes", `files:
+- name: escape
+  link: ../outside`, nil, true},
+		{"absolute", `files:
+- name: absolute
+  link: /etc/passwd`, nil, true},
+		{"content", `files:
+- name: content
+  content: test
+  link: other`, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := host.NewTest(platform.Linux, arch.AMD64)
+			h.OS.ChangeDirectory("/")
+			require.NoError(t, h.FS.MkdirAll("/template", 0755))
+			require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte(test.template), 0600))
+
+			linker := symlink.NewMemory(h.FS)
+			inter := NewInterpolateService(h)
+			svc := cast.NewService(h.FS, inter, h.Path, prompt.NewService(h.Console), linker, execute.NewMemory())
+			_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output"})
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for path, link := range test.want {
+				target, err := linker.Readlink(path)
+				require.NoError(t, err)
+				require.Equal(t, link, target)
+			}
+
+			// applying again leaves the links unchanged
+			resp, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output"})
+			require.NoError(t, err)
+			require.Equal(t, cast.ActionUnchanged, resp.Entries[1].Action)
+		})
+	}
+}
+
+func TestFolderRefFilters(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, WriteFiles(h, map[string]string{
+		"/template/.caster.yml": `folders:
+- name: assets
+  ref: static
+  include:
+  - "**/*.png"
+  exclude:
+  - cache`,
+		"/template/static/logo.png":        "logo",
+		"/template/static/readme.txt":      "readme",
+		"/template/static/img/icon.png":    "icon",
+		"/template/static/cache/cache.png": "cache",
+		"/template/static/empty/empty.txt": "empty",
+	}))
+
+	svc := NewCastService(h, execute.NewMemory())
+	_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output"})
+	require.NoError(t, err)
+
+	AssertContents(t, h, "/output/assets/logo.png", "logo")
+	AssertContents(t, h, "/output/assets/img/icon.png", "icon")
+	for _, path := range []string{
+		"/output/assets/readme.txt",
+		"/output/assets/cache",
+		"/output/assets/empty",
+	} {
+		ok, err := h.FS.Exists(path)
+		require.NoError(t, err)
+		require.False(t, ok, "expected '%s' to not exist", path)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	template := `hooks:
+  pre_apply:
+  - command: echo {{ .module }}
+  post_apply:
+  - command: go mod tidy
+  - command: git init
+    dir: src
+    env:
+      GIT_DIR: .git
+    when: .git
+files:
+- name: go.mod
+  content: module {{ .module }}`
+
+	type test struct {
+		name     string
+		request  cast.Request
+		commands []execute.Command
+		statuses []cast.HookStatus
+	}
+	tests := []test{
+		{"allowed", cast.Request{AllowHooks: true}, []execute.Command{
+			{Script: "echo github.com/test", Dir: "/output"},
+			{Script: "go mod tidy", Dir: "/output"},
+		}, []cast.HookStatus{cast.HookRan, cast.HookRan, cast.HookSkipped}},
+		{"when", cast.Request{AllowHooks: true, Variables: []models.Variable{{Key: "git", Value: "true", Format: models.FormatYaml}}}, []execute.Command{
+			{Script: "echo github.com/test", Dir: "/output"},
+			{Script: "go mod tidy", Dir: "/output"},
+			{Script: "git init", Dir: "/output/src", Env: map[string]string{"GIT_DIR": ".git"}},
+		}, []cast.HookStatus{cast.HookRan, cast.HookRan, cast.HookRan}},
+		{"not_allowed", cast.Request{}, nil,
+			[]cast.HookStatus{cast.HookSkipped, cast.HookSkipped, cast.HookSkipped}},
+		{"dry_run", cast.Request{AllowHooks: true, DryRun: true}, nil,
+			[]cast.HookStatus{cast.HookPlanned, cast.HookPlanned, cast.HookSkipped}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := host.NewTest(platform.Linux, arch.AMD64)
+			h.OS.ChangeDirectory("/")
+			require.NoError(t, h.FS.MkdirAll("/template", 0755))
+			require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+			executor := execute.NewMemory()
+			svc := NewCastService(h, executor)
+
+			request := test.request
+			request.Template = "/template"
+			request.Target = "/output"
+			request.Variables = append(request.Variables, models.Variable{Key: "module", Value: "github.com/test"})
+			resp, err := svc.Cast(&request)
+			require.NoError(t, err)
+
+			require.Equal(t, test.commands, executor.Commands())
+			var statuses []cast.HookStatus
+			for _, hook := range resp.Hooks {
+				statuses = append(statuses, hook.Status)
+			}
+			require.Equal(t, test.statuses, statuses)
+		})
+	}
+}
+
+func TestHookFailure(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, h.FS.MkdirAll("/template", 0755))
+	require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte(`hooks:
+  pre_apply:
+  - command: exit 1
+files:
+- name: test.txt`), 0600))
+
+	executor := execute.NewMemory(func(cmd *execute.Command) error {
+		return errors.New("exit status 1")
+	})
+	svc := NewCastService(h, executor)
+	_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output", AllowHooks: true})
+	require.Error(t, err)
+
+	ok, err := h.FS.Exists("/output/test.txt")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestWhen(t *testing.T) {
+	template := `files:
+- name: LICENSE
+  content: mit
+  when: eq .license "mit"
+- name: NOTICE
+  content: apache
+  when: eq .license "apache"
+- name: README.md
+  content: readme
+  when: "true"
+folders:
+- name: docker
+  when: .docker
+  files:
+  - name: Dockerfile
+    content: FROM scratch`
+
+	type test struct {
+		name      string
+		variables []models.Variable
+		exists    []string
+		missing   []string
+	}
+	tests := []test{
+		{"mit", []models.Variable{{Key: "license", Value: "mit"}},
+			[]string{"/output/LICENSE", "/output/README.md"},
+			[]string{"/output/NOTICE", "/output/docker"}},
+		{"apache_docker", []models.Variable{{Key: "license", Value: "apache"}, {Key: "docker", Value: "true", Format: models.FormatYaml}},
+			[]string{"/output/NOTICE", "/output/README.md", "/output/docker/Dockerfile"},
+			[]string{"/output/LICENSE"}},
+		{"missing_variables", nil,
+			[]string{"/output/README.md"},
+			[]string{"/output/LICENSE", "/output/NOTICE", "/output/docker"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := host.NewTest(platform.Linux, arch.AMD64)
+			h.OS.ChangeDirectory("/")
+			require.NoError(t, h.FS.MkdirAll("/template", 0755))
+			require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+			svc := NewCastService(h, execute.NewMemory())
+			_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output", Variables: test.variables})
+			require.NoError(t, err)
+
+			for _, path := range test.exists {
+				AssertExists(t, h, path)
+			}
+			for _, path := range test.missing {
+				ok, err := h.FS.Exists(path)
+				require.NoError(t, err)
+				require.False(t, ok, "expected '%s' to not exist", path)
+			}
+		})
+	}
+
+	t.Run("dry_run_reason", func(t *testing.T) {
+		h := host.NewTest(platform.Linux, arch.AMD64)
+		h.OS.ChangeDirectory("/")
+		require.NoError(t, h.FS.MkdirAll("/template", 0755))
+		require.NoError(t, h.FS.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+		svc := NewCastService(h, execute.NewMemory())
+		resp, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output", DryRun: true})
+		require.NoError(t, err)
+
+		reasons := map[string]string{}
+		for _, entry := range resp.Entries {
+			reasons[entry.Name] = entry.Reason
+		}
+		require.Equal(t, map[string]string{
+			"LICENSE":   `when 'eq .license "mit"' is false`,
+			"NOTICE":    `when 'eq .license "apache"' is false`,
+			"README.md": "",
+			"docker":    "when '.docker' is false",
+		}, reasons)
+	})
+}
+
+func TestForeach(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, WriteFiles(h, map[string]string{
+		"/template/.caster.yml": `folders:
+- name: {{ .region.name }}
+  foreach: .regions
+  as: region
+  folders:
+  - name: {{ .env }}
+    foreach: .region.environments
+    as: env
+    when: ne .env "skip"
+    files:
+    - name: main.tf
+      content: "{{ .region.name }}-{{ .env }} {{ .title }}"
+    - name: check.sh
+      content: "if [[ -f x ]]; then exit 1; fi"
+    - name: servers.toml
+      content: "[[servers]]"
+    - name: backend.tf
+      ref: backend.tf
+      template: true
+files:
+- name: {{ .item }}.txt
+  foreach: .tags
+  content: {{ .item | upper }}
+- name: README.md
+  content: {{ .title }}`,
+		"/template/backend.tf": "key = \"{{ .region.name }}/{{ .env }}\"",
+		"/data.yml": `regions:
+- name: eastus
+  environments: [sdbx, prod, skip]
+- name: westus
+  environments: [prod]
+tags: [a, b]
+title: infra`,
+	}))
+
+	svc := NewCastService(h, execute.NewMemory())
+	_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output", Variables: []models.Variable{{File: "/data.yml"}}})
+	require.NoError(t, err)
+
+	AssertContents(t, h, "/output/eastus/sdbx/main.tf", "eastus-sdbx infra")
+	AssertContents(t, h, "/output/eastus/prod/main.tf", "eastus-prod infra")
+	AssertContents(t, h, "/output/westus/prod/main.tf", "westus-prod infra")
+	AssertContents(t, h, "/output/westus/prod/backend.tf", `key = "westus/prod"`)
+	AssertContents(t, h, "/output/westus/prod/check.sh", "if [[ -f x ]]; then exit 1; fi")
+	AssertContents(t, h, "/output/westus/prod/servers.toml", "[[servers]]")
+	AssertContents(t, h, "/output/a.txt", "A")
+	AssertContents(t, h, "/output/b.txt", "B")
+	AssertContents(t, h, "/output/README.md", "infra")
+	ok, err := h.FS.Exists("/output/eastus/skip")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestForeachBlock(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, WriteFiles(h, map[string]string{
+		"/template/.caster.yml": "files:\n- name: test.txt\n  foreach: .tags\n  content: '{{ if .item }}yes{{ end }}'",
+	}))
+
+	svc := NewCastService(h, execute.NewMemory())
+	_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output", Variables: []models.Variable{{Key: "tags", Value: "[a]", Format: models.FormatYaml}}})
+	require.ErrorContains(t, err, "the foreach item can not be used in '{{ if .item }}'")
+}
+
+func TestForeachNotAList(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, WriteFiles(h, map[string]string{
+		"/template/.caster.yml": "files:\n- name: test.txt\n  foreach: .name",
+	}))
+
+	svc := NewCastService(h, execute.NewMemory())
+	_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output", Variables: []models.Variable{{Key: "name", Value: "test"}}})
+	require.Error(t, err)
+}
+
+func TestIncludes(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, WriteFiles(h, map[string]string{
+		"/template/.caster.yml": `includes:
+- template: ../components/license
+  variables:
+    author: {{ .author }}
+- template: ../components/workflow
+  target: .github/workflows
+  variables:
+    versions: {{ .versions | toJson }}
+files:
+- name: README.md
+  content: {{ .name }}`,
+		"/components/license/.caster.yml": `variables:
+- name: author
+  required: true
+- name: year
+  default: 2024
+files:
+- name: LICENSE
+  ref: MIT
+  template: true
+hooks:
+  post_apply:
+  - command: echo {{ .author }}
+    dir: docs`,
+		"/components/license/MIT":          "Copyright {{ .year }} {{ .author }}",
+		"/components/workflow/.caster.yml": "tree: true",
+		"/components/workflow/ci.yml":      "go: {{ range .versions }}{{ . }} {{ end }}",
+	}))
+
+	executor := execute.NewMemory()
+	svc := NewCastService(h, executor)
+	_, err := svc.Cast(&cast.Request{
+		Template:   "/template",
+		Target:     "/output",
+		AllowHooks: true,
+		Variables: []models.Variable{
+			{Key: "name", Value: "test"},
+			{Key: "author", Value: "caster"},
+			{Key: "versions", Value: `["1.19", "1.20"]`, Format: models.FormatJson},
+		},
+	})
+	require.NoError(t, err)
+
+	AssertContents(t, h, "/output/README.md", "test")
+	AssertContents(t, h, "/output/LICENSE", "Copyright 2024 caster")
+	AssertContents(t, h, "/output/.github/workflows/ci.yml", "go: 1.19 1.20 ")
+	require.Equal(t, []execute.Command{{Script: "echo caster", Dir: "/output/docs"}}, executor.Commands())
+}
+
+func TestExtends(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, WriteFiles(h, map[string]string{
+		"/base/.caster.yml": `variables:
+- name: license
+  default: MIT
+files:
+- name: README.md
+  content: base
+- name: LICENSE
+  ref: LICENSE
+  template: true
+- name: Makefile
+  content: build
+folders:
+- name: docs
+  files:
+  - name: index.md
+    content: docs`,
+		"/base/LICENSE": "{{ .license }}",
+		"/child/.caster.yml": `extends: ../base
+files:
+- name: README.md
+  content: {{ .name }}
+- name: Makefile
+  delete: true
+- name: go.mod
+  content: module {{ .name }}
+folders:
+- name: docs
+  files:
+  - name: guide.md
+    content: guide`,
+	}))
+
+	svc := NewCastService(h, execute.NewMemory())
+	_, err := svc.Cast(&cast.Request{
+		Template:  "/child",
+		Target:    "/output",
+		Variables: []models.Variable{{Key: "name", Value: "test"}},
+	})
+	require.NoError(t, err)
+
+	AssertContents(t, h, "/output/README.md", "test")
+	AssertContents(t, h, "/output/LICENSE", "MIT")
+	AssertContents(t, h, "/output/go.mod", "module test")
+	AssertContents(t, h, "/output/docs/index.md", "docs")
+	AssertContents(t, h, "/output/docs/guide.md", "guide")
+	exists, err := h.FS.Exists("/output/Makefile")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestExtendsTree(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, WriteFiles(h, map[string]string{
+		"/base/.caster.yml":  "",
+		"/base/main.go":      "package {{ .name }}",
+		"/child/.caster.yml": "extends: ../base",
+		"/child/README.md":   "child",
+	}))
+
+	svc := NewCastService(h, execute.NewMemory())
+	_, err := svc.Cast(&cast.Request{
+		Template:  "/child",
+		Target:    "/output",
+		Variables: []models.Variable{{Key: "name", Value: "main"}},
+	})
+	require.NoError(t, err)
+
+	// only the base without files and folders is a tree, the child only extends it
+	AssertContents(t, h, "/output/main.go", "package main")
+	exists, err := h.FS.Exists("/output/README.md")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestArchive(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	for _, file := range []struct{ name, content string }{
+		{"go-cli/.caster.yml", "files:\n- name: main.go\n  ref: main.go.tmpl\n  template: true\nfolders:\n- name: docs\n  ref: docs"},
+		{"go-cli/main.go.tmpl", "package {{ .name }}"},
+		{"go-cli/docs/index.md", "docs"},
+	} {
+		require.NoError(t, w.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content)), Typeflag: tar.TypeReg}))
+		_, err := w.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, h.FS.MkdirAll("/releases", 0755))
+	require.NoError(t, h.FS.WriteFile("/releases/templates.tar.gz", buf.Bytes(), 0644))
+
+	svc := NewCastService(h, execute.NewMemory())
+	_, err := svc.Cast(&cast.Request{
+		Template:  "/releases/templates.tar.gz//go-cli",
+		Target:    "/output",
+		Variables: []models.Variable{{Key: "name", Value: "main"}},
+	})
+	require.NoError(t, err)
+
+	AssertContents(t, h, "/output/main.go", "package main")
+	AssertContents(t, h, "/output/docs/index.md", "docs")
+	exists, err := h.FS.Exists("/releases/templates.tar.gz/go-cli")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestTreeIgnored(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, WriteFiles(h, map[string]string{
+		"/template/.caster.yml":        "tree: true",
+		"/template/.caster.json":       "{}",
+		"/template/main.go":            "package main",
+		"/template/.git/config":        "[core]",
+		"/template/.hg/hgrc":           "[ui]",
+		"/template/nested/.caster.yml": "files: []",
+		"/template/nested/README.md":   "nested",
+	}))
+
+	svc := NewCastService(h, execute.NewMemory())
+	_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output"})
+	require.NoError(t, err)
+
+	AssertContents(t, h, "/output/main.go", "package main")
+	AssertContents(t, h, "/output/nested/README.md", "nested")
+	for _, path := range []string{"/output/.caster.yml", "/output/.caster.json", "/output/.git", "/output/.hg", "/output/nested/.caster.yml"} {
+		ok, err := h.FS.Exists(path)
+		require.NoError(t, err)
+		require.False(t, ok, "expected '%s' to not exist", path)
+	}
+}
+
+func TestConditionalFilesNotTree(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, WriteFiles(h, map[string]string{
+		"/template/.caster.yml": "{{ if .docs }}\nfiles:\n- name: docs.md\n  content: docs\n{{ end }}",
+		"/template/secret.txt":  "secret",
+	}))
+
+	svc := NewCastService(h, execute.NewMemory())
+	resp, err := svc.Cast(&cast.Request{Template: "/template", Target: "/output", DryRun: true})
+	require.NoError(t, err)
+	require.Empty(t, resp.Entries)
+}
+
+func TestTreeIntoItself(t *testing.T) {
+	h := host.NewTest(platform.Linux, arch.AMD64)
+	h.OS.ChangeDirectory("/")
+	require.NoError(t, WriteFiles(h, map[string]string{
+		"/template/.caster.yml": "tree: true",
+		"/template/main.go":     "package {{ .name }}",
+	}))
+
+	svc := NewCastService(h, execute.NewMemory())
+	variables := []models.Variable{{Key: "name", Value: "main"}}
+	_, err := svc.Cast(&cast.Request{Template: "/template", Target: "/template", Variables: variables})
+	require.Error(t, err)
+
+	// archives are not written into the template directory
+	resp, err := svc.Cast(&cast.Request{Template: "/template", Target: "/template", Variables: variables, DryRun: true, OutputFormat: cast.OutputTar})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp.Entries))
+	require.Equal(t, "main.go", resp.Entries[0].Name)
+}
+
+func TestOutputFormat(t *testing.T) {
+	template := `files:
+- name: README.md
+  content: {{ .name }}
+- name: LATEST
+  link: README.md
+folders:
+- name: cmd
+  files:
+  - name: main.go
+    content: package main
+hooks:
+  post_apply:
+  - command: go mod tidy`
+
+	// readArchive returns the content of each file and the target of each link by name
+	readArchive := func(t *testing.T, format string, content []byte) map[string]string {
+		result := map[string]string{}
+		if format == cast.OutputZip {
+			reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+			require.NoError(t, err)
+			for _, f := range reader.File {
+				rc, err := f.Open()
+				require.NoError(t, err)
+				data, err := io.ReadAll(rc)
+				require.NoError(t, err)
+				require.NoError(t, rc.Close())
+				result[f.Name] = string(data)
+			}
+			return result
+		}
+		var r io.Reader = bytes.NewReader(content)
+		if format == cast.OutputTarGz {
+			gz, err := gzip.NewReader(r)
+			require.NoError(t, err)
+			r = gz
+		}
+		reader := tar.NewReader(r)
+		for {
+			header, err := reader.Next()
+			if errors.Is(err, io.EOF) {
+				return result
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			result[header.Name] = string(data) + header.Linkname
+		}
+	}
+
+	for _, format := range []string{cast.OutputZip, cast.OutputTar, cast.OutputTarGz} {
+		t.Run(format, func(t *testing.T) {
+			h := host.NewTest(platform.Linux, arch.AMD64)
+			h.OS.ChangeDirectory("/")
+			require.NoError(t, WriteFiles(h, map[string]string{"/template/.caster.yml": template}))
+			executor := execute.NewMemory()
+			svc := NewCastService(h, executor)
+
+			var buf bytes.Buffer
+			resp, err := svc.Cast(&cast.Request{
+				Template:     "/template",
+				Target:       "/output",
+				OutputFormat: format,
+				Output:       &buf,
+				AllowHooks:   true,
+				Variables:    []models.Variable{{Key: "name", Value: "test"}},
+			})
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{
+				"README.md":   "test",
+				"LATEST":      "README.md",
+				"cmd/":        "",
+				"cmd/main.go": "package main",
+			}, readArchive(t, format, buf.Bytes()))
+
+			// nothing is written to the target directory and hooks do not run
+			exists, err := h.FS.Exists("/output")
+			require.NoError(t, err)
+			require.False(t, exists)
+			require.Empty(t, executor.Commands())
+			require.Equal(t, cast.HookSkipped, resp.Hooks[0].Status)
+
+			// without a writer the archive is written to the target
+			_, err = svc.Cast(&cast.Request{
+				Template:     "/template",
+				Target:       "/dist/project." + format,
+				OutputFormat: format,
+				Variables:    []models.Variable{{Key: "name", Value: "test"}},
+			})
+			require.NoError(t, err)
+			written, err := h.FS.ReadFile("/dist/project." + format)
+			require.NoError(t, err)
+			require.Equal(t, "test", readArchive(t, format, written)["README.md"])
+		})
+	}
+	t.Run("modes", func(t *testing.T) {
+		h := host.NewTest(platform.Linux, arch.AMD64)
+		h.OS.ChangeDirectory("/")
+		require.NoError(t, WriteFiles(h, map[string]string{"/template/.caster.yml": template}))
+		svc := NewCastService(h, execute.NewMemory())
+
+		var buf bytes.Buffer
+		_, err := svc.Cast(&cast.Request{
+			Template:     "/template",
+			OutputFormat: cast.OutputTar,
+			Output:       &buf,
+			Variables:    []models.Variable{{Key: "name", Value: "test"}},
+		})
+		require.NoError(t, err)
+
+		// the umask is applied so default modes are not world writable
+		reader := tar.NewReader(&buf)
+		for {
+			header, err := reader.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			if header.Typeflag != tar.TypeSymlink {
+				require.Zero(t, header.Mode&0002, "expected '%s' to not be world writable", header.Name)
+			}
+		}
+	})
+	t.Run("directory_target", func(t *testing.T) {
+		h := host.NewTest(platform.Linux, arch.AMD64)
+		h.OS.ChangeDirectory("/working")
+		require.NoError(t, h.FS.MkdirAll("/working", 0755))
+		require.NoError(t, WriteFiles(h, map[string]string{"/template/.caster.yml": template}))
+		svc := NewCastService(h, execute.NewMemory())
+
+		_, err := svc.Cast(&cast.Request{
+			Template:     "/template",
+			OutputFormat: cast.OutputZip,
+			Variables:    []models.Variable{{Key: "name", Value: "test"}},
+		})
+		require.ErrorContains(t, err, "target '/working' is a directory")
+	})
+	t.Run("invalid", func(t *testing.T) {
+		h := host.NewTest(platform.Linux, arch.AMD64)
+		svc := NewCastService(h, execute.NewMemory())
+		_, err := svc.Cast(&cast.Request{Template: "/template", OutputFormat: "rar"})
+		require.ErrorContains(t, err, "unrecognized output format 'rar'")
+	})
+}
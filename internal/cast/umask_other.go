@@ -0,0 +1,11 @@
+//go:build !unix
+
+package cast
+
+import "io/fs"
+
+// umask returns the file mode creation mask of the process. Platforms without a umask use the common
+// default of 022 so archives written there do not contain world writable entries.
+func umask() fs.FileMode {
+	return 0022
+}
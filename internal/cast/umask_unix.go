@@ -0,0 +1,15 @@
+//go:build unix
+
+package cast
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// umask returns the file mode creation mask of the process
+func umask() fs.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return fs.FileMode(mask)
+}
@@ -0,0 +1,25 @@
+package cast
+
+import (
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// evaluate evaluates the when expression against the data. An empty expression is true.
+// The expression is a template pipeline like '.enabled' or 'eq .license "mit"' that is
+// evaluated with the truth rules of the template if action. The literals true and false are also accepted.
+func evaluate(when string, funcs template.FuncMap, data any) (bool, error) {
+	when = strings.TrimSpace(when)
+	if len(when) == 0 {
+		return true, nil
+	}
+	if b, err := strconv.ParseBool(when); err == nil {
+		return b, nil
+	}
+	result, err := renderTemplate("when", []byte("{{ if "+when+" }}true{{ else }}false{{ end }}"), funcs, data)
+	if err != nil {
+		return false, err
+	}
+	return string(result) == "true", nil
+}
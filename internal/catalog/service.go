@@ -0,0 +1,160 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/patrickhuber/go-xplat/env"
+	"github.com/patrickhuber/go-xplat/filepath"
+	afs "github.com/patrickhuber/go-xplat/fs"
+	"github.com/patrickhuber/go-xplat/os"
+)
+
+const (
+	// TemplatePathEnv is the environment variable containing a list of template directories
+	TemplatePathEnv = "CASTER_TEMPLATE_PATH"
+	// ConfigHomeEnv is the environment variable for the user configuration directory
+	ConfigHomeEnv = "XDG_CONFIG_HOME"
+)
+
+// Template is a named template in the catalog
+type Template struct {
+	Name string `yaml:"name" json:"name"`
+	Path string `yaml:"path" json:"path"`
+}
+
+// Service finds named templates in the configured template directories
+type Service interface {
+	List() ([]Template, error)
+	Get(name string) (*Template, error)
+	Directories() []string
+}
+
+// NewService creates a new instance of the catalog service
+func NewService(fs afs.FS, env env.Environment, o os.OS, path *filepath.Processor) Service {
+	return &service{
+		fs:   fs,
+		env:  env,
+		os:   o,
+		path: path,
+	}
+}
+
+type service struct {
+	fs   afs.FS
+	env  env.Environment
+	os   os.OS
+	path *filepath.Processor
+}
+
+// Directories returns the template directories in search order.
+// Directories in CASTER_TEMPLATE_PATH are searched before the user configuration directory.
+func (s *service) Directories() []string {
+	separator := ":"
+	if s.os.Platform().IsWindows() {
+		separator = ";"
+	}
+
+	var directories []string
+	for _, dir := range strings.Split(s.env.Get(TemplatePathEnv), separator) {
+		dir = strings.TrimSpace(dir)
+		if len(dir) == 0 {
+			continue
+		}
+		directories = append(directories, dir)
+	}
+
+	configHome := strings.TrimSpace(s.env.Get(ConfigHomeEnv))
+	if len(configHome) == 0 {
+		configHome = s.path.Join(s.os.Home(), ".config")
+	}
+	return append(directories, s.path.Join(configHome, "caster", "templates"))
+}
+
+// List returns the templates in all template directories sorted by name.
+// When two directories contain a template with the same name, the first directory wins.
+func (s *service) List() ([]Template, error) {
+	templates := map[string]Template{}
+	for _, dir := range s.Directories() {
+		ok, err := s.fs.Exists(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		entries, err := s.fs.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if _, ok := templates[name]; ok {
+				continue
+			}
+			path := s.path.Join(dir, name)
+			ok, err := s.isTemplate(path)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			templates[name] = Template{
+				Name: name,
+				Path: path,
+			}
+		}
+	}
+
+	var list []Template
+	for _, template := range templates {
+		list = append(list, template)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// Get returns the template with the given name
+func (s *service) Get(name string) (*Template, error) {
+	templates, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, template := range templates {
+		if template.Name == name {
+			return &template, nil
+		}
+	}
+	return nil, fmt.Errorf("template '%s' not found in template directories [%s]", name, strings.Join(s.Directories(), ", "))
+}
+
+// isTemplate returns true if the path is a directory containing a caster file
+func (s *service) isTemplate(path string) (bool, error) {
+	info, err := s.fs.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.IsDir() {
+		return false, nil
+	}
+	for _, file := range []string{".caster.yml", ".caster.json"} {
+		ok, err := s.fs.Exists(s.path.Join(path, file))
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,81 @@
+package catalog_test
+
+import (
+	"testing"
+
+	"github.com/patrickhuber/caster/internal/catalog"
+	"github.com/patrickhuber/go-xplat/env"
+	"github.com/patrickhuber/go-xplat/filepath"
+	afs "github.com/patrickhuber/go-xplat/fs"
+	"github.com/patrickhuber/go-xplat/os"
+	"github.com/patrickhuber/go-xplat/platform"
+	"github.com/stretchr/testify/require"
+)
+
+type ServiceTestContext struct {
+	fs   afs.FS
+	e    env.Environment
+	o    os.OS
+	path *filepath.Processor
+	svc  catalog.Service
+}
+
+func CreateServiceTestContext(t *testing.T) *ServiceTestContext {
+	o := os.NewMock(os.WithPlatform(platform.Linux))
+	path := filepath.NewProcessorWithOS(o)
+	fs := afs.NewMemory(afs.WithProcessor(path))
+	e := env.NewMemory()
+	return &ServiceTestContext{
+		fs:   fs,
+		e:    e,
+		o:    o,
+		path: path,
+		svc:  catalog.NewService(fs, e, o, path),
+	}
+}
+
+func (cx *ServiceTestContext) WriteTemplate(t *testing.T, dir string) {
+	require.NoError(t, cx.fs.MkdirAll(dir, 0666))
+	require.NoError(t, cx.fs.WriteFile(cx.path.Join(dir, ".caster.yml"), []byte("files: []"), 0666))
+}
+
+func TestService(t *testing.T) {
+	t.Run("directories", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.e.Set(catalog.TemplatePathEnv, "/one:/two"))
+		want := []string{"/one", "/two", cx.path.Join(cx.o.Home(), ".config", "caster", "templates")}
+		require.Equal(t, want, cx.svc.Directories())
+	})
+	t.Run("config_home", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.e.Set(catalog.ConfigHomeEnv, "/config"))
+		require.Equal(t, []string{"/config/caster/templates"}, cx.svc.Directories())
+	})
+	t.Run("list", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.e.Set(catalog.TemplatePathEnv, "/one:/two:/missing"))
+		cx.WriteTemplate(t, "/one/go-cli")
+		cx.WriteTemplate(t, "/two/go-cli")
+		cx.WriteTemplate(t, "/two/dockerfile")
+		require.NoError(t, cx.fs.MkdirAll("/two/not-a-template", 0666))
+
+		templates, err := cx.svc.List()
+		require.NoError(t, err)
+		require.Equal(t, []catalog.Template{
+			{Name: "dockerfile", Path: "/two/dockerfile"},
+			{Name: "go-cli", Path: "/one/go-cli"},
+		}, templates)
+	})
+	t.Run("get", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		dir := cx.path.Join(cx.o.Home(), ".config", "caster", "templates", "go-cli")
+		cx.WriteTemplate(t, dir)
+
+		template, err := cx.svc.Get("go-cli")
+		require.NoError(t, err)
+		require.Equal(t, dir, template.Path)
+
+		_, err = cx.svc.Get("missing")
+		require.Error(t, err)
+	})
+}
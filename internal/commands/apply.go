@@ -1,12 +1,18 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
 
 	"github.com/patrickhuber/caster/internal/cast"
+	"github.com/patrickhuber/caster/internal/catalog"
 	"github.com/patrickhuber/caster/internal/global"
+	"github.com/patrickhuber/caster/internal/interpolate"
 	"github.com/patrickhuber/caster/internal/models"
+	"github.com/patrickhuber/caster/internal/prompt"
 	"github.com/patrickhuber/go-di"
 	"github.com/patrickhuber/go-xplat/console"
 	"github.com/patrickhuber/go-xplat/env"
@@ -14,18 +20,39 @@ import (
 )
 
 const (
-	ApplyTemplateFlag = "template"
-	ApplyNameFlag     = "name"
-	ApplyOutFlag      = "out"
-	ApplyVarFlag      = "var"
-	ApplyVarFileFlag  = "var-file"
+	ApplyTemplateFlag       = "template"
+	ApplyNameFlag           = "name"
+	ApplyOutFlag            = "out"
+	ApplyVarFlag            = "var"
+	ApplyVarFileFlag        = "var-file"
+	ApplyVarJsonFlag        = "var-json"
+	ApplyDryRunFlag         = "dry-run"
+	ApplyPlanFlag           = "plan-format"
+	ApplyOnConflictFlag     = "on-conflict"
+	ApplyNonInteractiveFlag = "non-interactive"
+	ApplySaveVarsFlag       = "save-vars"
+	ApplyListMergeFlag      = "list-merge"
+	ApplyListMergeKeyFlag   = "list-merge-key"
+	ApplyEnvPrefixFlag      = "env-prefix"
+	ApplyNoEnvFlag          = "no-env"
+	ApplyAllowHooksFlag     = "allow-hooks"
+	ApplyOfflineFlag        = "offline"
+	ApplyOutputFormatFlag   = "output-format"
 )
 
+const (
+	PlanFormatTree = "tree"
+	PlanFormatJson = "json"
+)
+
+// StdoutTarget is the target that writes archives to stdout
+const StdoutTarget = "-"
+
 var Apply = &cli.Command{
 	Name:        "apply",
 	Description: "applies the specified template to the target directory",
 	Usage:       "Applies the specified template to the target directory",
-	UsageText:   "caster apply [-t|--template <TEMPLATEDIR|TEMPLATEFILE>] [-n|--name <TEMPLATENAME>] [OUTDIR]",
+	UsageText:   "caster apply [-t|--template <TEMPLATEDIR|TEMPLATEFILE>] [-n|--name <TEMPLATENAME>] [--on-conflict overwrite|skip|fail|backup|prompt] [--allow-hooks] [--non-interactive] [--save-vars <FILE>] [--dry-run [--plan-format tree|json]] [--output-format dir|zip|tar|tar.gz] [OUTDIR|OUTFILE|-]",
 	Action:      ApplyAction,
 	Flags: []cli.Flag{
 		&cli.StringFlag{
@@ -38,12 +65,72 @@ var Apply = &cli.Command{
 			Aliases: []string{"n"},
 		},
 		&cli.StringSliceFlag{
-			Name: ApplyVarFlag,
+			Name:  ApplyVarFlag,
+			Usage: "sets a variable in the format key=value or key:=yaml",
 		},
 		&cli.StringSliceFlag{
 			Name:      ApplyVarFileFlag,
 			TakesFile: true,
 		},
+		&cli.StringSliceFlag{
+			Name:  ApplyVarJsonFlag,
+			Usage: "sets a variable to a json value in the format key=json",
+		},
+		&cli.BoolFlag{
+			Name:  ApplyDryRunFlag,
+			Usage: "prints the planned changes without writing them",
+		},
+		&cli.StringFlag{
+			Name:  ApplyPlanFlag,
+			Usage: "the format of the dry run plan (tree|json)",
+			Value: PlanFormatTree,
+		},
+		&cli.StringFlag{
+			Name:  ApplyOutputFormatFlag,
+			Usage: "writes the target as a directory or an archive (dir|zip|tar|tar.gz). Archives are written to stdout when the target is -",
+			Value: cast.OutputDir,
+		},
+		&cli.StringFlag{
+			Name:  ApplyOnConflictFlag,
+			Usage: "the policy for files that already exist in the target (overwrite|skip|fail|backup|prompt)",
+			Value: cast.ConflictOverwrite,
+		},
+		&cli.BoolFlag{
+			Name:  ApplyNonInteractiveFlag,
+			Usage: "disables prompting for declared variables that are missing a value",
+		},
+		&cli.StringFlag{
+			Name:      ApplySaveVarsFlag,
+			Usage:     "saves the prompted variables to a file that can be passed to --var-file",
+			TakesFile: true,
+		},
+		&cli.StringFlag{
+			Name:  ApplyListMergeFlag,
+			Usage: "the strategy for merging lists from multiple variable sources (replace|append|merge)",
+			Value: interpolate.ListReplace,
+		},
+		&cli.StringFlag{
+			Name:  ApplyListMergeKeyFlag,
+			Usage: "the key used to match list items with the merge strategy",
+			Value: interpolate.DefaultListMergeKey,
+		},
+		&cli.StringFlag{
+			Name:  ApplyEnvPrefixFlag,
+			Usage: "the prefix of environment variables that are read as variables",
+			Value: interpolate.DefaultEnvPrefix,
+		},
+		&cli.BoolFlag{
+			Name:  ApplyNoEnvFlag,
+			Usage: "disables reading variables from environment variables",
+		},
+		&cli.BoolFlag{
+			Name:  ApplyAllowHooksFlag,
+			Usage: "runs the pre and post apply hooks of the template. Hooks of named templates always run",
+		},
+		&cli.BoolFlag{
+			Name:  ApplyOfflineFlag,
+			Usage: "reads git and archive templates from the cache and fails instead of fetching them",
+		},
 	},
 }
 
@@ -52,13 +139,26 @@ type ApplyCommand struct {
 	Environment env.Environment `inject:""`
 	Service     cast.Service    `inject:""`
 	Console     console.Console `inject:""`
+	Catalog     catalog.Service `inject:""`
+	Prompt      prompt.Service  `inject:""`
 }
 
 type ApplyOptions struct {
-	Template  string
-	Name      string
-	Target    string
-	Variables []models.Variable
+	Template       string
+	Name           string
+	Target         string
+	Variables      []models.Variable
+	DryRun         bool
+	PlanFormat     string
+	OnConflict     string
+	NonInteractive bool
+	SaveVariables  string
+	ListMerge      string
+	ListMergeKey   string
+	EnvPrefix      string
+	AllowHooks     bool
+	Offline        bool
+	OutputFormat   string
 }
 
 func (cmd *ApplyCommand) Execute() error {
@@ -67,14 +167,119 @@ func (cmd *ApplyCommand) Execute() error {
 	// clone the variable slice
 	variables = append(variables, cmd.Options.Variables...)
 
+	template, err := resolveTemplate(cmd.Catalog, cmd.Options.Template, cmd.Options.Name)
+	if err != nil {
+		return err
+	}
+
+	// prompts need a terminal, piped input would block or answer with the wrong lines.
+	// archives written to stdout can not be mixed with prompts
+	interactive := !cmd.Options.NonInteractive && cmd.Prompt.Interactive()
+	var output io.Writer
+	if cmd.Options.Target == StdoutTarget {
+		if cmd.Options.OutputFormat == cast.OutputDir || len(cmd.Options.OutputFormat) == 0 {
+			return fmt.Errorf("the target '%s' requires an archive --%s of zip, tar or tar.gz", StdoutTarget, ApplyOutputFormatFlag)
+		}
+		if !cmd.Options.DryRun {
+			output = cmd.Console.Out()
+			interactive = false
+		}
+	}
+
 	// create apply request
 	request := &cast.Request{
-		Template:  cmd.Options.Template,
-		Variables: variables,
-		Target:    cmd.Options.Target,
+		Template:      template,
+		Variables:     variables,
+		Target:        cmd.Options.Target,
+		DryRun:        cmd.Options.DryRun,
+		OnConflict:    cmd.Options.OnConflict,
+		Interactive:   interactive,
+		SaveVariables: cmd.Options.SaveVariables,
+		ListMerge:     cmd.Options.ListMerge,
+		ListMergeKey:  cmd.Options.ListMergeKey,
+		EnvPrefix:     cmd.Options.EnvPrefix,
+		AllowHooks:    cmd.Options.AllowHooks,
+		Offline:       cmd.Options.Offline,
+		OutputFormat:  cmd.Options.OutputFormat,
+		Output:        output,
+	}
+	// hooks declared by templates in the catalog are trusted, not the hooks of templates they include or extend
+	if len(cmd.Options.Name) > 0 {
+		request.TrustedDirs = cmd.Catalog.Directories()
+	}
+	resp, err := cmd.Service.Cast(request)
+	if err != nil {
+		return err
+	}
+	if !cmd.Options.DryRun {
+		return cmd.writeSkippedHooks(resp.Hooks)
 	}
-	err := cmd.Service.Cast(request)
-	return err
+	return cmd.writePlan(resp)
+}
+
+func (cmd *ApplyCommand) writeSkippedHooks(hooks []cast.Hook) error {
+	for _, hook := range hooks {
+		if hook.Status != cast.HookSkipped {
+			continue
+		}
+		_, err := fmt.Fprintf(cmd.Console.Error(), "skipped %s hook '%s' : %s\n", hook.Stage, hook.Command, hook.Reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cmd *ApplyCommand) writePlan(resp *cast.Response) error {
+	switch cmd.Options.PlanFormat {
+	case PlanFormatJson:
+		encoder := json.NewEncoder(cmd.Console.Out())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(resp)
+	case PlanFormatTree, "":
+		err := writeTree(cmd.Console.Out(), resp.Target, planNodes(resp.Entries))
+		if err != nil || len(resp.Hooks) == 0 {
+			return err
+		}
+		return writeTree(cmd.Console.Out(), "hooks", hookNodes(resp.Hooks))
+	}
+	return fmt.Errorf("unrecognized plan format '%s'", cmd.Options.PlanFormat)
+}
+
+func planNodes(entries []cast.Entry) []treeNode {
+	var nodes []treeNode
+	for _, entry := range entries {
+		var label string
+		switch {
+		case len(entry.Reason) > 0 && entry.Kind == cast.KindFolder:
+			label = fmt.Sprintf("%s/ (%s: %s)", entry.Name, entry.Action, entry.Reason)
+		case len(entry.Reason) > 0:
+			label = fmt.Sprintf("%s (%s: %s)", entry.Name, entry.Action, entry.Reason)
+		case entry.Kind == cast.KindFolder:
+			label = fmt.Sprintf("%s/ (%s)", entry.Name, entry.Action)
+		case entry.Kind == cast.KindLink:
+			label = fmt.Sprintf("%s -> %s (%s)", entry.Name, entry.Link, entry.Action)
+		default:
+			label = fmt.Sprintf("%s (%s, %d B)", entry.Name, entry.Action, entry.Size)
+		}
+		nodes = append(nodes, treeNode{
+			label:    label,
+			children: planNodes(entry.Entries),
+		})
+	}
+	return nodes
+}
+
+func hookNodes(hooks []cast.Hook) []treeNode {
+	var nodes []treeNode
+	for _, hook := range hooks {
+		label := fmt.Sprintf("%s: %s (%s)", hook.Stage, hook.Command, hook.Status)
+		if len(hook.Reason) > 0 {
+			label = fmt.Sprintf("%s: %s (%s, %s)", hook.Stage, hook.Command, hook.Status, hook.Reason)
+		}
+		nodes = append(nodes, treeNode{label: label})
+	}
+	return nodes
 }
 
 func ApplyAction(ctx *cli.Context) error {
@@ -85,21 +290,36 @@ func ApplyAction(ctx *cli.Context) error {
 		return err
 	}
 
+	if ctx.IsSet(ApplyTemplateFlag) && ctx.IsSet(ApplyNameFlag) {
+		return fmt.Errorf("the --%s and --%s flags can not be used together", ApplyTemplateFlag, ApplyNameFlag)
+	}
+
 	variables, err := getFlagVariables(ctx)
 	if err != nil {
 		return err
 	}
 
-	envVariables, err := getEnvironmentVariables(cmd.Environment)
+	envVariables, err := getEnvironmentVariables(cmd.Environment, ctx.String(ApplyEnvPrefixFlag), ctx.Bool(ApplyNoEnvFlag))
 	if err != nil {
 		return err
 	}
 
 	cmd.Options = ApplyOptions{
-		Template:  ctx.String(ApplyTemplateFlag),
-		Name:      ctx.String(ApplyNameFlag),
-		Target:    ctx.Args().First(),
-		Variables: append(variables, envVariables...),
+		Template:       ctx.String(ApplyTemplateFlag),
+		Name:           ctx.String(ApplyNameFlag),
+		Target:         ctx.Args().First(),
+		Variables:      append(variables, envVariables...),
+		DryRun:         ctx.Bool(ApplyDryRunFlag),
+		PlanFormat:     ctx.String(ApplyPlanFlag),
+		OnConflict:     ctx.String(ApplyOnConflictFlag),
+		NonInteractive: ctx.Bool(ApplyNonInteractiveFlag),
+		SaveVariables:  ctx.String(ApplySaveVarsFlag),
+		ListMerge:      ctx.String(ApplyListMergeFlag),
+		ListMergeKey:   ctx.String(ApplyListMergeKeyFlag),
+		EnvPrefix:      ctx.String(ApplyEnvPrefixFlag),
+		AllowHooks:     ctx.Bool(ApplyAllowHooksFlag),
+		Offline:        ctx.Bool(ApplyOfflineFlag),
+		OutputFormat:   ctx.String(ApplyOutputFormatFlag),
 	}
 
 	return cmd.Execute()
@@ -108,45 +328,111 @@ func ApplyAction(ctx *cli.Context) error {
 func getFlagVariables(ctx *cli.Context) ([]models.Variable, error) {
 	variables := []models.Variable{}
 
-	names := []string{}
+	// the command context only contains positional arguments, the parent context
+	// contains the raw command arguments which preserve the order of the flags.
+	// values are read from the raw arguments because slice flags split values on commas.
 	args := ctx.Args().Slice()
-	for _, a := range args {
-		if strings.Contains(a, "-"+ApplyVarFileFlag) {
-			names = append(names, ApplyVarFileFlag)
-		} else if strings.Contains(a, "-"+ApplyVarFlag) {
-			names = append(names, ApplyVarFlag)
-		}
+	if lineage := ctx.Lineage(); len(lineage) > 1 {
+		args = lineage[1].Args().Slice()
 	}
-	varFlags := ctx.StringSlice(ApplyVarFlag)
-	varFileFlags := ctx.StringSlice(ApplyVarFileFlag)
-	varIndex := 0
-	varFileIndex := 0
-	for _, name := range names {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			break
+		}
+		name := flagName(args[i])
+		switch name {
+		case ApplyVarFileFlag, ApplyVarFlag, ApplyVarJsonFlag:
+		default:
+			continue
+		}
+
+		_, value, ok := strings.Cut(args[i], "=")
+		if !ok {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag --%s is missing a value", name)
+			}
+			i++
+			value = args[i]
+		}
+
 		switch name {
 		case ApplyVarFlag:
-			varFlag := varFlags[varIndex]
-			split := strings.Split(varFlag, "=")
-			if len(split) != 2 {
-				return nil, fmt.Errorf("unable to parse var flag '%s'. Expected flag in format --var \"key=value\"", varFlag)
+			variable, err := parseVarFlag(ApplyVarFlag, value)
+			if err != nil {
+				return nil, err
 			}
-			variables = append(variables, models.Variable{Key: split[0], Value: split[1]})
-			varIndex++
+			// a key ending in ':' like key:=value decodes the value as yaml
+			if strings.HasSuffix(variable.Key, ":") {
+				variable.Key = strings.TrimSuffix(variable.Key, ":")
+				variable.Format = models.FormatYaml
+			}
+			variables = append(variables, *variable)
 		case ApplyVarFileFlag:
-			variables = append(variables, models.Variable{File: varFileFlags[varFileIndex]})
-			varFileIndex++
+			variables = append(variables, parseVarFileFlag(value))
+		case ApplyVarJsonFlag:
+			variable, err := parseVarFlag(ApplyVarJsonFlag, value)
+			if err != nil {
+				return nil, err
+			}
+			variable.Format = models.FormatJson
+			variables = append(variables, *variable)
 		}
 	}
 	return variables, nil
 }
 
-// getEnvironmentVariables returns the list of the environment variable keys that match the caster prefix
-func getEnvironmentVariables(e env.Environment) ([]models.Variable, error) {
+// parseVarFileFlag splits an optional format prefix like 'toml:config' from the file path
+func parseVarFileFlag(value string) models.Variable {
+	format, path, ok := strings.Cut(value, ":")
+	if !ok {
+		return models.Variable{File: value}
+	}
+	switch format {
+	case models.FormatYaml, models.FormatJson, models.FormatToml, models.FormatEnv, models.FormatProperties:
+		return models.Variable{File: path, Format: format}
+	}
+	return models.Variable{File: value}
+}
+
+// parseVarFlag splits the flag value on the first '='
+func parseVarFlag(flag, value string) (*models.Variable, error) {
+	key, v, ok := strings.Cut(value, "=")
+	if !ok || len(strings.TrimSpace(key)) == 0 {
+		return nil, fmt.Errorf("unable to parse %s flag '%s'. Expected flag in format --%s \"key=value\"", flag, value, flag)
+	}
+	return &models.Variable{Key: key, Value: v}, nil
+}
+
+// flagName returns the name of the flag in the argument or an empty string if the argument is not a flag
+func flagName(arg string) string {
+	if !strings.HasPrefix(arg, "-") {
+		return ""
+	}
+	name := strings.TrimLeft(arg, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// getEnvironmentVariables returns the sorted list of the environment variable keys that match the prefix.
+// No variables are returned when disabled is set.
+func getEnvironmentVariables(e env.Environment, prefix string, disabled bool) ([]models.Variable, error) {
 	variables := []models.Variable{}
+	if disabled {
+		return variables, nil
+	}
+	if len(prefix) == 0 {
+		prefix = interpolate.DefaultEnvPrefix
+	}
 	for k := range e.Export() {
-		if !strings.HasPrefix(k, "CASTER_VAR_") {
+		if !strings.HasPrefix(k, prefix) {
 			continue
 		}
 		variables = append(variables, models.Variable{Env: k})
 	}
+	sort.Slice(variables, func(i, j int) bool {
+		return variables[i].Env < variables[j].Env
+	})
 	return variables, nil
 }
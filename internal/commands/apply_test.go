@@ -1,8 +1,17 @@
 package commands_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
 	"testing"
 
+	"github.com/patrickhuber/caster/internal/cast"
+	"github.com/patrickhuber/caster/internal/execute"
+	"github.com/patrickhuber/go-di"
+	"github.com/patrickhuber/go-xplat/console"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -145,4 +154,272 @@ func TestApply(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, []byte(want), content)
 	})
+
+	t.Run("name", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.env.Set("CASTER_TEMPLATE_PATH", "/templates"))
+		require.NoError(t, cx.fs.MkdirAll("/templates/go-cli", 0666))
+		require.NoError(t, cx.fs.WriteFile("/templates/go-cli/.caster.yml", []byte("files:\n- name: test.txt\n  content: go-cli"), 0600))
+
+		args := []string{"caster", "apply", "-n", "go-cli", "/out"}
+
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		content, err := cx.fs.ReadFile("/out/test.txt")
+		require.NoError(t, err)
+		require.Equal(t, []byte("go-cli"), content)
+	})
+
+	t.Run("name_and_template", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		args := []string{"caster", "apply", "-n", "go-cli", "-t", "/template"}
+		err := cx.app.Run(args)
+		require.Error(t, err)
+	})
+
+	t.Run("dry_run", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		template := "files:\n- name: test.txt\n  content: test\nfolders:\n- name: sub\n  files:\n  - name: sub.txt\n"
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+		args := []string{"caster", "apply", "-t", "/template", "--dry-run", "/out"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		ok, err := cx.fs.Exists("/out")
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		want := `/out
+├── test.txt (create, 4 B)
+└── sub/ (create)
+    └── sub.txt (create, 0 B)
+`
+		require.Equal(t, want, buf.String())
+	})
+
+	t.Run("dry_run_json", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("files:\n- name: test.txt\n  content: test"), 0600))
+
+		args := []string{"caster", "apply", "-t", "/template", "--dry-run", "--plan-format", "json", "/out"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+
+		var resp cast.Response
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &resp))
+		require.Equal(t, "/out", resp.Target)
+		require.Equal(t, 1, len(resp.Entries))
+		require.Equal(t, cast.ActionCreate, resp.Entries[0].Action)
+		require.Equal(t, int64(4), resp.Entries[0].Size)
+	})
+
+	t.Run("on_conflict", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("files:\n- name: test.txt\n  content: new"), 0600))
+		require.NoError(t, cx.fs.WriteFile("/working/test.txt", []byte("old"), 0600))
+
+		args := []string{"caster", "apply", "-t", "/template", "--on-conflict", "skip"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		content, err := cx.fs.ReadFile("/working/test.txt")
+		require.NoError(t, err)
+		require.Equal(t, []byte("old"), content)
+	})
+}
+
+func TestApplyVariables(t *testing.T) {
+	type test struct {
+		name    string
+		args    []string
+		content string
+	}
+	tests := []test{
+		{"first_equals", []string{"--var", "key=a=b"}, "a=b"},
+		{"nested", []string{"--var", "key.name=value"}, "map[name:value]"},
+		{"typed", []string{"--var", "key:=[1, 2]"}, "[1 2]"},
+		{"json", []string{"--var-json", `key={"enabled": true}`}, "map[enabled:true]"},
+		{"order", []string{"--var-json", `key=1`, "--var", "key=2"}, "2"},
+		{"file_format", []string{"--var-file", "properties:/data/vars"}, "value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cx := SetupTestContext(t)
+			err := cx.fs.WriteFile("/template/.caster.yml", []byte("files:\n- name: test.txt\n  content: '{{ .key }}'"), 0600)
+			require.NoError(t, err)
+			err = cx.fs.WriteFile("/data/vars", []byte("key=value"), 0600)
+			require.NoError(t, err)
+
+			args := append([]string{"caster", "apply", "-t", "/template"}, test.args...)
+			err = cx.app.Run(args)
+			require.NoError(t, err)
+
+			content, err := cx.fs.ReadFile("/working/test.txt")
+			require.NoError(t, err)
+			require.Equal(t, test.content, string(content))
+		})
+	}
+}
+
+func TestApplyPrompt(t *testing.T) {
+	template := `variables:
+- name: module
+  required: true
+files:
+- name: test.txt
+  content: {{ .module }}`
+
+	t.Run("interactive", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600))
+		cx.console.(console.Memory).InBuffer().WriteString("github.com/test\n")
+
+		err := cx.app.Run([]string{"caster", "apply", "-t", "/template", "--save-vars", "/data/answers.yml"})
+		require.NoError(t, err)
+
+		content, err := cx.fs.ReadFile("/working/test.txt")
+		require.NoError(t, err)
+		require.Equal(t, "github.com/test", string(content))
+
+		saved, err := cx.fs.ReadFile("/data/answers.yml")
+		require.NoError(t, err)
+		require.Equal(t, "module: github.com/test\n", string(saved))
+	})
+	t.Run("dry_run_does_not_save", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600))
+		cx.console.(console.Memory).InBuffer().WriteString("github.com/test\n")
+
+		err := cx.app.Run([]string{"caster", "apply", "-t", "/template", "--save-vars", "/data/answers.yml", "--dry-run"})
+		require.NoError(t, err)
+
+		ok, err := cx.fs.Exists("/data/answers.yml")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+	t.Run("non_interactive", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600))
+		cx.console.(console.Memory).InBuffer().WriteString("github.com/test\n")
+
+		err := cx.app.Run([]string{"caster", "apply", "-t", "/template", "--non-interactive"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "'module' is required")
+	})
+}
+
+func TestApplyHooks(t *testing.T) {
+	template := `hooks:
+  post_apply:
+  - command: go mod tidy
+files:
+- name: go.mod
+  content: module test`
+
+	t.Run("allow_hooks", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+		err := cx.app.Run([]string{"caster", "apply", "-t", "/template", "--allow-hooks"})
+		require.NoError(t, err)
+
+		executor, err := di.Resolve[execute.Executor](cx.container)
+		require.NoError(t, err)
+		require.Equal(t, []execute.Command{{Script: "go mod tidy", Dir: "/working"}}, executor.(execute.Memory).Commands())
+	})
+	t.Run("not_allowed", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+		err := cx.app.Run([]string{"caster", "apply", "-t", "/template"})
+		require.NoError(t, err)
+
+		executor, err := di.Resolve[execute.Executor](cx.container)
+		require.NoError(t, err)
+		require.Empty(t, executor.(execute.Memory).Commands())
+
+		buf, ok := cx.console.Error().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Contains(t, buf.String(), "skipped post_apply hook 'go mod tidy'")
+	})
+	t.Run("catalog", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.env.Set("CASTER_TEMPLATE_PATH", "/templates"))
+		require.NoError(t, cx.fs.MkdirAll("/templates/go-cli", 0666))
+		require.NoError(t, cx.fs.MkdirAll("/base", 0666))
+		require.NoError(t, cx.fs.WriteFile("/templates/go-cli/.caster.yml", []byte("extends: /base\n"+template), 0600))
+		require.NoError(t, cx.fs.WriteFile("/base/.caster.yml", []byte("hooks:\n  post_apply:\n  - command: make\nfiles:\n- name: Makefile"), 0600))
+
+		err := cx.app.Run([]string{"caster", "apply", "-n", "go-cli"})
+		require.NoError(t, err)
+
+		// only the hooks of the catalog template run, the hooks of the template it extends are skipped
+		executor, err := di.Resolve[execute.Executor](cx.container)
+		require.NoError(t, err)
+		require.Equal(t, []execute.Command{{Script: "go mod tidy", Dir: "/working"}}, executor.(execute.Memory).Commands())
+
+		buf, ok := cx.console.Error().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Contains(t, buf.String(), "skipped post_apply hook 'make'")
+	})
+	t.Run("dry_run", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+		err := cx.app.Run([]string{"caster", "apply", "-t", "/template", "--allow-hooks", "--dry-run"})
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Equal(t, "/working\n└── go.mod (create, 11 B)\nhooks\n└── post_apply: go mod tidy (planned)\n", buf.String())
+	})
+}
+
+func TestApplyOutputFormat(t *testing.T) {
+	t.Run("stdout", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("files:\n- name: test.txt\n  content: test"), 0600))
+
+		err := cx.app.Run([]string{"caster", "apply", "-t", "/template", "--output-format", "tar", "-"})
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		reader := tar.NewReader(buf)
+		header, err := reader.Next()
+		require.NoError(t, err)
+		require.Equal(t, "test.txt", header.Name)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, "test", string(content))
+
+		ok, err = cx.fs.Exists("/working/test.txt")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+	t.Run("file", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("files:\n- name: test.txt\n  content: test"), 0600))
+
+		err := cx.app.Run([]string{"caster", "apply", "-t", "/template", "--output-format", "zip", "/dist/project.zip"})
+		require.NoError(t, err)
+
+		ok, err := cx.fs.Exists("/dist/project.zip")
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+	t.Run("stdout requires an archive", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("files:\n- name: test.txt"), 0600))
+
+		err := cx.app.Run([]string{"caster", "apply", "-t", "/template", "-"})
+		require.ErrorContains(t, err, "requires an archive --output-format")
+	})
 }
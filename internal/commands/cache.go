@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/patrickhuber/caster/internal/cache"
+	"github.com/patrickhuber/caster/internal/global"
+	"github.com/patrickhuber/go-di"
+	"github.com/patrickhuber/go-xplat/console"
+	"github.com/urfave/cli/v2"
+)
+
+var Cache = &cli.Command{
+	Name:        "cache",
+	Description: "manages the cache of git and archive templates",
+	Usage:       "manages the cache of git and archive templates",
+	UsageText:   "caster cache list|prune|clear",
+	Subcommands: []*cli.Command{
+		{
+			Name:        "list",
+			Aliases:     []string{"ls"},
+			Description: "lists the cached revisions of each template source",
+			Usage:       "lists the cached revisions of each template source",
+			UsageText:   "caster cache list",
+			Action:      CacheListAction,
+		},
+		{
+			Name:        "prune",
+			Description: "removes every cached revision except the most recent revision of each template source",
+			Usage:       "removes every cached revision except the most recent revision of each template source",
+			UsageText:   "caster cache prune",
+			Action:      CachePruneAction,
+		},
+		{
+			Name:        "clear",
+			Description: "removes the cache directory",
+			Usage:       "removes the cache directory",
+			UsageText:   "caster cache clear",
+			Action:      CacheClearAction,
+		},
+	},
+}
+
+type CacheCommand struct {
+	Cache   cache.Service   `inject:""`
+	Console console.Console `inject:""`
+}
+
+func newCacheCommand(ctx *cli.Context) (*CacheCommand, error) {
+	cmd := &CacheCommand{}
+	resolver := ctx.App.Metadata[global.DependencyInjectionContainer].(di.Resolver)
+	err := di.Inject(resolver, cmd)
+	return cmd, err
+}
+
+func CacheListAction(ctx *cli.Context) error {
+	cmd, err := newCacheCommand(ctx)
+	if err != nil {
+		return err
+	}
+	return cmd.List()
+}
+
+func CachePruneAction(ctx *cli.Context) error {
+	cmd, err := newCacheCommand(ctx)
+	if err != nil {
+		return err
+	}
+	return cmd.Prune()
+}
+
+func CacheClearAction(ctx *cli.Context) error {
+	cmd, err := newCacheCommand(ctx)
+	if err != nil {
+		return err
+	}
+	return cmd.Clear()
+}
+
+// List writes the source, revision and fetch time of each cached revision
+func (cmd *CacheCommand) List() error {
+	entries, err := cmd.Cache.List()
+	if err != nil {
+		return err
+	}
+	return cmd.write(entries)
+}
+
+// Prune removes the older revisions of each source and writes the removed revisions
+func (cmd *CacheCommand) Prune() error {
+	removed, err := cmd.Cache.Prune()
+	if err != nil {
+		return err
+	}
+	return cmd.write(removed)
+}
+
+// Clear removes the cache directory
+func (cmd *CacheCommand) Clear() error {
+	err := cmd.Cache.Clear()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.Console.Out(), "removed %s\n", cmd.Cache.Dir())
+	return err
+}
+
+func (cmd *CacheCommand) write(entries []cache.Entry) error {
+	writer := tabwriter.NewWriter(cmd.Console.Out(), 0, 4, 2, ' ', 0)
+	for _, entry := range entries {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", entry.Source, entry.Revision, entry.Fetched.Format(time.RFC3339))
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,59 @@
+package commands_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/patrickhuber/caster/internal/cache"
+	"github.com/patrickhuber/go-di"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	setup := func(t *testing.T) (*TestContext, cache.Service) {
+		cx := SetupTestContext(t)
+		svc, err := di.Resolve[cache.Service](cx.container)
+		require.NoError(t, err)
+		fetched := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		for i, revision := range []string{"1111", "2222"} {
+			entry := cache.Entry{Source: "https://example.com/go-cli.tar.gz", Revision: revision, Key: revision, Fetched: fetched.Add(time.Duration(i) * time.Hour)}
+			require.NoError(t, cx.fs.MkdirAll(svc.Path(entry.Key), 0755))
+			require.NoError(t, svc.Add(entry))
+		}
+		return cx, svc
+	}
+	t.Run("list", func(t *testing.T) {
+		cx, _ := setup(t)
+		require.NoError(t, cx.app.Run([]string{"caster", "cache", "list"}))
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Equal(t, "https://example.com/go-cli.tar.gz  2222  2024-01-02T04:04:05Z\n"+
+			"https://example.com/go-cli.tar.gz  1111  2024-01-02T03:04:05Z\n", buf.String())
+	})
+	t.Run("prune", func(t *testing.T) {
+		cx, svc := setup(t)
+		require.NoError(t, cx.app.Run([]string{"caster", "cache", "prune"}))
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Equal(t, "https://example.com/go-cli.tar.gz  1111  2024-01-02T03:04:05Z\n", buf.String())
+		entries, err := svc.List()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(entries))
+	})
+	t.Run("clear", func(t *testing.T) {
+		cx, svc := setup(t)
+		require.NoError(t, cx.app.Run([]string{"caster", "cache", "clear"}))
+
+		exists, err := cx.fs.Exists(svc.Dir())
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+	t.Run("offline apply fails when the template is not cached", func(t *testing.T) {
+		cx, _ := setup(t)
+		err := cx.app.Run([]string{"caster", "apply", "--offline", "-t", "https://example.com/other.tar.gz", "/output"})
+		require.ErrorIs(t, err, cache.ErrOffline)
+	})
+}
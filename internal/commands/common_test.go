@@ -64,6 +64,8 @@ func SetupTestContext(t *testing.T) *TestContext {
 			commands.Interpolate,
 			commands.Apply,
 			commands.Initialize,
+			commands.List,
+			commands.Cache,
 		},
 		Reader:    con.In(),
 		ErrWriter: con.Error(),
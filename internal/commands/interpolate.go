@@ -1,21 +1,46 @@
 package commands
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/patrickhuber/caster/internal/cast"
+	"github.com/patrickhuber/caster/internal/catalog"
 	"github.com/patrickhuber/caster/internal/global"
 	"github.com/patrickhuber/caster/internal/interpolate"
 	"github.com/patrickhuber/caster/internal/models"
 	"github.com/patrickhuber/go-di"
 	"github.com/patrickhuber/go-xplat/console"
 	"github.com/patrickhuber/go-xplat/env"
+	afs "github.com/patrickhuber/go-xplat/fs"
 	"github.com/urfave/cli/v2"
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	InterpolateTemplateFlag = "template"
-	InterpolateNameFlag     = "name"
-	InterpolateVarFlag      = "var"
-	InterpolateVarFileFlag  = "var-file"
+	InterpolateTemplateFlag     = "template"
+	InterpolateNameFlag         = "name"
+	InterpolateVarFlag          = "var"
+	InterpolateVarFileFlag      = "var-file"
+	InterpolateVarJsonFlag      = "var-json"
+	InterpolateListMergeFlag    = "list-merge"
+	InterpolateListMergeKeyFlag = "list-merge-key"
+	InterpolatePrintVarsFlag    = "print-vars"
+	InterpolateEnvPrefixFlag    = "env-prefix"
+	InterpolateNoEnvFlag        = "no-env"
+	InterpolateExplainVarsFlag  = "explain-vars"
+	InterpolateOfflineFlag      = "offline"
+	InterpolateFormatFlag       = "format"
+	InterpolateOutputFlag       = "output"
+)
+
+const (
+	InterpolateFormatYaml = "yaml"
+	InterpolateFormatJson = "json"
+	InterpolateFormatTree = "tree"
+	InterpolateFormatRaw  = "raw"
 )
 
 var Interpolate = &cli.Command{
@@ -23,7 +48,7 @@ var Interpolate = &cli.Command{
 	Aliases:     []string{"inter"},
 	Description: "interpolates the specified template and outputs the result",
 	Usage:       "interpolates the specified template and outputs the result",
-	UsageText:   "caster interpolate [-t|--template <TEMPLATEDIR|TEMPLATEFILE>] [-n|--name <TEMPLATENAME>]",
+	UsageText:   "caster interpolate [-t|--template <TEMPLATEDIR|TEMPLATEFILE>] [-n|--name <TEMPLATENAME>] [--list-merge replace|append|merge] [--print-vars|--explain-vars] [--format yaml|json|tree|raw] [--output <FILE>]",
 	Action:      InterpolateAction,
 	Flags: []cli.Flag{
 		&cli.StringFlag{
@@ -42,6 +67,51 @@ var Interpolate = &cli.Command{
 			Name:      InterpolateVarFileFlag,
 			TakesFile: true,
 		},
+		&cli.StringSliceFlag{
+			Name:  InterpolateVarJsonFlag,
+			Usage: "sets a variable to a json value in the format key=json",
+		},
+		&cli.StringFlag{
+			Name:  InterpolateListMergeFlag,
+			Usage: "the strategy for merging lists from multiple variable sources (replace|append|merge)",
+			Value: interpolate.ListReplace,
+		},
+		&cli.StringFlag{
+			Name:  InterpolateListMergeKeyFlag,
+			Usage: "the key used to match list items with the merge strategy",
+			Value: interpolate.DefaultListMergeKey,
+		},
+		&cli.BoolFlag{
+			Name:  InterpolatePrintVarsFlag,
+			Usage: "prints the merged variables instead of the interpolated template",
+		},
+		&cli.StringFlag{
+			Name:  InterpolateEnvPrefixFlag,
+			Usage: "the prefix of environment variables that are read as variables",
+			Value: interpolate.DefaultEnvPrefix,
+		},
+		&cli.BoolFlag{
+			Name:  InterpolateNoEnvFlag,
+			Usage: "disables reading variables from environment variables",
+		},
+		&cli.BoolFlag{
+			Name:  InterpolateExplainVarsFlag,
+			Usage: "prints the source of every variable and the sources it overrides",
+		},
+		&cli.BoolFlag{
+			Name:  InterpolateOfflineFlag,
+			Usage: "reads git and archive templates from the cache and fails instead of fetching them",
+		},
+		&cli.StringFlag{
+			Name:  InterpolateFormatFlag,
+			Usage: "the output format (yaml|json|tree|raw). tree draws the files and folders of the result, raw prints the rendered caster file before it is parsed",
+			Value: InterpolateFormatYaml,
+		},
+		&cli.StringFlag{
+			Name:      InterpolateOutputFlag,
+			Usage:     "writes the output to the file instead of stdout",
+			TakesFile: true,
+		},
 	},
 }
 
@@ -50,12 +120,23 @@ type InterpolateCommand struct {
 	Environment env.Environment     `inject:""`
 	Service     interpolate.Service `inject:""`
 	Console     console.Console     `inject:""`
+	Catalog     catalog.Service     `inject:""`
+	Cast        cast.Service        `inject:""`
+	FS          afs.FS              `inject:""`
 }
 
 type InterpolateOptions struct {
-	Template  string
-	Name      string
-	Variables []models.Variable
+	Template     string
+	Name         string
+	Variables    []models.Variable
+	ListMerge    string
+	ListMergeKey string
+	PrintVars    bool
+	EnvPrefix    string
+	ExplainVars  bool
+	Offline      bool
+	Format       string
+	Output       string
 }
 
 func InterpolateAction(ctx *cli.Context) error {
@@ -67,20 +148,32 @@ func InterpolateAction(ctx *cli.Context) error {
 		return err
 	}
 
+	if ctx.IsSet(InterpolateTemplateFlag) && ctx.IsSet(InterpolateNameFlag) {
+		return fmt.Errorf("the --%s and --%s flags can not be used together", InterpolateTemplateFlag, InterpolateNameFlag)
+	}
+
 	variables, err := getFlagVariables(ctx)
 	if err != nil {
 		return err
 	}
 
-	envVariables, err := getEnvironmentVariables(cmd.Environment)
+	envVariables, err := getEnvironmentVariables(cmd.Environment, ctx.String(InterpolateEnvPrefixFlag), ctx.Bool(InterpolateNoEnvFlag))
 	if err != nil {
 		return err
 	}
 
 	cmd.Options = InterpolateOptions{
-		Template:  ctx.String(InterpolateTemplateFlag),
-		Name:      ctx.String(InterpolateNameFlag),
-		Variables: append(variables, envVariables...),
+		Template:     ctx.String(InterpolateTemplateFlag),
+		Name:         ctx.String(InterpolateNameFlag),
+		Variables:    append(variables, envVariables...),
+		ListMerge:    ctx.String(InterpolateListMergeFlag),
+		ListMergeKey: ctx.String(InterpolateListMergeKeyFlag),
+		PrintVars:    ctx.Bool(InterpolatePrintVarsFlag),
+		EnvPrefix:    ctx.String(InterpolateEnvPrefixFlag),
+		ExplainVars:  ctx.Bool(InterpolateExplainVarsFlag),
+		Offline:      ctx.Bool(InterpolateOfflineFlag),
+		Format:       ctx.String(InterpolateFormatFlag),
+		Output:       ctx.String(InterpolateOutputFlag),
 	}
 
 	return cmd.Execute()
@@ -90,24 +183,136 @@ func (cmd *InterpolateCommand) Execute() error {
 	variables := []models.Variable{}
 	for _, v := range cmd.Options.Variables {
 		variables = append(variables, models.Variable{
-			File:  v.File,
-			Key:   v.Key,
-			Value: v.Value,
-			Env:   v.Env,
+			File:   v.File,
+			Key:    v.Key,
+			Value:  v.Value,
+			Env:    v.Env,
+			Format: v.Format,
 		})
 	}
 
+	template, err := resolveTemplate(cmd.Catalog, cmd.Options.Template, cmd.Options.Name)
+	if err != nil {
+		return err
+	}
+
+	// the output is written to the file once it is complete
+	var w io.Writer = cmd.Console.Out()
+	var buf bytes.Buffer
+	if len(cmd.Options.Output) > 0 {
+		w = &buf
+	}
+
+	err = cmd.write(w, template, variables)
+	if err != nil || len(cmd.Options.Output) == 0 {
+		return err
+	}
+	return cmd.FS.WriteFile(cmd.Options.Output, buf.Bytes(), 0644)
+}
+
+func (cmd *InterpolateCommand) write(w io.Writer, template string, variables []models.Variable) error {
+	format := cmd.Options.Format
+	switch format {
+	case "":
+		format = InterpolateFormatYaml
+	case InterpolateFormatYaml, InterpolateFormatJson, InterpolateFormatTree, InterpolateFormatRaw:
+	default:
+		return fmt.Errorf("unrecognized format '%s'. Expected one of yaml, json, tree or raw", format)
+	}
+
+	// the tree is the dry run plan of an archive so it contains every file regardless of the target
+	if format == InterpolateFormatTree && !cmd.Options.PrintVars && !cmd.Options.ExplainVars {
+		resp, err := cmd.Cast.Cast(&cast.Request{
+			Template:     template,
+			Variables:    variables,
+			ListMerge:    cmd.Options.ListMerge,
+			ListMergeKey: cmd.Options.ListMergeKey,
+			EnvPrefix:    cmd.Options.EnvPrefix,
+			Offline:      cmd.Options.Offline,
+			DryRun:       true,
+			OutputFormat: cast.OutputTar,
+		})
+		if err != nil {
+			return err
+		}
+		return writeTree(w, template, entryNodes(resp.Entries))
+	}
+
 	// create apply request
 	request := &interpolate.Request{
-		Template:  cmd.Options.Template,
-		Variables: variables,
+		Template:     template,
+		Variables:    variables,
+		ListMerge:    cmd.Options.ListMerge,
+		ListMergeKey: cmd.Options.ListMergeKey,
+		EnvPrefix:    cmd.Options.EnvPrefix,
+		Offline:      cmd.Options.Offline,
+		Raw:          format == InterpolateFormatRaw && !cmd.Options.PrintVars && !cmd.Options.ExplainVars,
 	}
 	resp, err := cmd.Service.Interpolate(request)
 	if err != nil {
 		return err
 	}
-	encoder := yaml.NewEncoder(cmd.Console.Out())
-	encoder.SetIndent(2)
-	defer encoder.Close()
-	return encoder.Encode(resp.Caster)
+	if cmd.Options.ExplainVars {
+		return writeExplanations(w, resp.Explanations)
+	}
+
+	var value any = resp.Caster
+	if cmd.Options.PrintVars {
+		value = resp.Data
+	}
+	switch {
+	case format == InterpolateFormatJson:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case format == InterpolateFormatYaml:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(value)
+	case cmd.Options.PrintVars:
+		return fmt.Errorf("the --%s flag supports the yaml and json formats", InterpolatePrintVarsFlag)
+	}
+	_, err = io.WriteString(w, resp.Raw)
+	return err
+}
+
+// entryNodes labels the planned files with their size. Skipped entries are not part of the result.
+func entryNodes(entries []cast.Entry) []treeNode {
+	var nodes []treeNode
+	for _, entry := range entries {
+		if entry.Action == cast.ActionSkip {
+			continue
+		}
+		var label string
+		switch entry.Kind {
+		case cast.KindFolder:
+			label = entry.Name + "/"
+		case cast.KindLink:
+			label = fmt.Sprintf("%s -> %s", entry.Name, entry.Link)
+		default:
+			label = fmt.Sprintf("%s (%d B)", entry.Name, entry.Size)
+		}
+		nodes = append(nodes, treeNode{
+			label:    label,
+			children: entryNodes(entry.Entries),
+		})
+	}
+	return nodes
+}
+
+func writeExplanations(w io.Writer, explanations []interpolate.Explanation) error {
+	for _, explanation := range explanations {
+		_, err := fmt.Fprintf(w, "%s: %v\n  set by %s\n", explanation.Key, explanation.Value, explanation.Source)
+		if err != nil {
+			return err
+		}
+		for _, source := range explanation.Overrides {
+			_, err = fmt.Fprintf(w, "  overrides %s\n", source)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
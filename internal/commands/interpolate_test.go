@@ -173,4 +173,176 @@ func TestInterpolate(t *testing.T) {
 		have := buf.String()
 		require.Equal(t, want, have, cmp.Diff(have, want))
 	})
+	t.Run("print_vars", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		cx.fs.WriteFile("/template/.caster.yml", []byte("files: []"), 0600)
+		cx.fs.WriteFile("/data/base.yml", []byte("db:\n  host: localhost\n  port: 5432\nregions: [eastus]"), 0600)
+		cx.fs.WriteFile("/data/prod.yml", []byte("db:\n  host: prod\nregions: [westus]"), 0600)
+
+		args := []string{"caster", "interpolate", "--var-file", "/data/base.yml", "--var-file", "/data/prod.yml", "--list-merge", "append", "--print-vars", "-t", "/template"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		want := `db:
+  host: prod
+  port: 5432
+regions:
+  - eastus
+  - westus
+`
+		have := buf.String()
+		require.Equal(t, want, have, cmp.Diff(have, want))
+	})
+	t.Run("env_prefix", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		cx.fs.WriteFile("/template/.caster.yml", []byte("files: []"), 0600)
+		cx.env.Set("CASTER_VAR_skipped", "value")
+		cx.env.Set("APP_db__port", "5432")
+
+		args := []string{"caster", "interpolate", "--env-prefix", "APP_", "--print-vars", "-t", "/template"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Equal(t, "db:\n  port: 5432\n", buf.String())
+	})
+	t.Run("no_env", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		cx.fs.WriteFile("/template/.caster.yml", []byte("files: []"), 0600)
+		cx.env.Set("CASTER_VAR_key", "value")
+
+		args := []string{"caster", "interpolate", "--no-env", "--print-vars", "-t", "/template"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Equal(t, "{}\n", buf.String())
+	})
+	t.Run("explain_vars", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		cx.fs.WriteFile("/template/.caster.yml", []byte("files: []"), 0600)
+		cx.fs.WriteFile("/data/1.yml", []byte("key: first"), 0600)
+		cx.env.Set("CASTER_VAR_key", "env")
+
+		args := []string{"caster", "interpolate", "--var-file", "/data/1.yml", "--var", "key=second", "--explain-vars", "-t", "/template"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		want := `key: second
+  set by flag key
+  overrides file /data/1.yml:1
+  overrides env CASTER_VAR_key
+`
+		have := buf.String()
+		require.Equal(t, want, have, cmp.Diff(have, want))
+	})
+	t.Run("format_json", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		cx.fs.WriteFile("/template/.caster.yml", []byte("files:\n- name: '{{ .name }}.txt'"), 0600)
+
+		args := []string{"caster", "interpolate", "--var", "name=test", "--format", "json", "-t", "/template"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Contains(t, buf.String(), `"name": "test.txt"`)
+	})
+	t.Run("format_tree", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		template := `files:
+- name: README.md
+  content: {{ .name }}
+- name: LICENSE
+  when: false
+folders:
+- name: cmd
+  files:
+  - name: main.go
+    content: package main
+`
+		cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600)
+
+		args := []string{"caster", "interpolate", "--var", "name=test", "--format", "tree", "-t", "/template"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		want := `/template
+├── README.md (4 B)
+└── cmd/
+    └── main.go (12 B)
+`
+		have := buf.String()
+		require.Equal(t, want, have, cmp.Diff(have, want))
+
+		ok, err = cx.fs.Exists("/working/README.md")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+	t.Run("format_tree_working_directory", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		cx.fs.WriteFile("/working/.caster.yml", []byte("tree: true"), 0600)
+		cx.fs.WriteFile("/working/main.go", []byte("package {{ .name }}"), 0600)
+
+		args := []string{"caster", "interpolate", "--var", "name=main", "--format", "tree"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		want := `.
+└── main.go (12 B)
+`
+		have := buf.String()
+		require.Equal(t, want, have, cmp.Diff(have, want))
+	})
+	t.Run("format_raw", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		// the rendered value breaks the yaml so only the raw format succeeds
+		template := "files:\n- name: {{ .name }}\n"
+		cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600)
+
+		args := []string{"caster", "interpolate", "--var", "name=a: b: c", "-t", "/template"}
+		require.Error(t, cx.app.Run(args))
+
+		cx = SetupTestContext(t)
+		cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600)
+		args = []string{"caster", "interpolate", "--var", "name=a: b: c", "--format", "raw", "-t", "/template"}
+		require.NoError(t, cx.app.Run(args))
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Equal(t, "files:\n- name: a: b: c\n", buf.String())
+	})
+	t.Run("output", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		cx.fs.WriteFile("/template/.caster.yml", []byte("files:\n- name: test.txt"), 0600)
+
+		args := []string{"caster", "interpolate", "--output", "/data/caster.yml", "-t", "/template"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		content, err := cx.fs.ReadFile("/data/caster.yml")
+		require.NoError(t, err)
+		require.Equal(t, "files:\n  - name: test.txt\n", string(content))
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Empty(t, buf.String())
+	})
+	t.Run("format_invalid", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		cx.fs.WriteFile("/template/.caster.yml", []byte("files: []"), 0600)
+
+		args := []string{"caster", "interpolate", "--format", "xml", "-t", "/template"}
+		require.ErrorContains(t, cx.app.Run(args), "unrecognized format 'xml'")
+	})
 }
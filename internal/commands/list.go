@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/patrickhuber/caster/internal/catalog"
+	"github.com/patrickhuber/caster/internal/global"
+	"github.com/patrickhuber/go-di"
+	"github.com/patrickhuber/go-xplat/console"
+	"github.com/urfave/cli/v2"
+)
+
+var List = &cli.Command{
+	Name:        "list",
+	Aliases:     []string{"ls"},
+	Description: "lists the named templates in the template directories",
+	Usage:       "lists the named templates in the template directories",
+	UsageText:   "caster list",
+	Action:      ListAction,
+}
+
+type ListCommand struct {
+	Catalog catalog.Service `inject:""`
+	Console console.Console `inject:""`
+}
+
+func ListAction(ctx *cli.Context) error {
+	cmd := &ListCommand{}
+	resolver := ctx.App.Metadata[global.DependencyInjectionContainer].(di.Resolver)
+	err := di.Inject(resolver, cmd)
+	if err != nil {
+		return err
+	}
+	return cmd.Execute()
+}
+
+func (cmd *ListCommand) Execute() error {
+	templates, err := cmd.Catalog.List()
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(cmd.Console.Out(), 0, 4, 2, ' ', 0)
+	for _, template := range templates {
+		fmt.Fprintf(writer, "%s\t%s\n", template.Name, template.Path)
+	}
+	return writer.Flush()
+}
+
+// resolveTemplate returns the template path for the template name if the name is set.
+// Otherwise the template is returned.
+func resolveTemplate(c catalog.Service, template, name string) (string, error) {
+	if len(name) == 0 {
+		return template, nil
+	}
+	t, err := c.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return t.Path, nil
+}
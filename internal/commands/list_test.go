@@ -0,0 +1,25 @@
+package commands_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	t.Run("basic", func(t *testing.T) {
+		cx := SetupTestContext(t)
+		require.NoError(t, cx.env.Set("CASTER_TEMPLATE_PATH", "/templates"))
+		require.NoError(t, cx.fs.MkdirAll("/templates/go-cli", 0666))
+		require.NoError(t, cx.fs.WriteFile("/templates/go-cli/.caster.yml", []byte("files: []"), 0600))
+
+		args := []string{"caster", "list"}
+		err := cx.app.Run(args)
+		require.NoError(t, err)
+
+		buf, ok := cx.console.Out().(*bytes.Buffer)
+		require.True(t, ok)
+		require.Equal(t, "go-cli  /templates/go-cli\n", buf.String())
+	})
+}
@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+)
+
+// treeNode is a labeled node written by writeTree
+type treeNode struct {
+	label    string
+	children []treeNode
+}
+
+// writeTree writes the root and its children as an indented tree
+func writeTree(w io.Writer, root string, nodes []treeNode) error {
+	_, err := fmt.Fprintln(w, root)
+	if err != nil {
+		return err
+	}
+	return writeTreeNodes(w, "", nodes)
+}
+
+func writeTreeNodes(w io.Writer, indent string, nodes []treeNode) error {
+	for i, node := range nodes {
+		branch, childIndent := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, childIndent = "└── ", "    "
+		}
+		_, err := fmt.Fprintf(w, "%s%s%s\n", indent, branch, node.label)
+		if err != nil {
+			return err
+		}
+		err = writeTreeNodes(w, indent+childIndent, node.children)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Package execute runs shell commands through an interface
+package execute
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/patrickhuber/go-xplat/console"
+)
+
+// Command is a shell script run in a working directory with additional environment variables
+type Command struct {
+	Script string
+	Dir    string
+	Env    map[string]string
+}
+
+// Executor runs commands
+type Executor interface {
+	Run(cmd *Command) error
+}
+
+// NewOS creates an executor that runs commands with the operating system shell.
+// The output of the command is written to the console.
+func NewOS(console console.Console) Executor {
+	return &osExecutor{
+		console: console,
+	}
+}
+
+type osExecutor struct {
+	console console.Console
+}
+
+func (e *osExecutor) Run(cmd *Command) error {
+	name, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		name, flag = "cmd", "/C"
+	}
+	c := exec.Command(name, flag, cmd.Script)
+	c.Dir = cmd.Dir
+	c.Stdin = e.console.In()
+	c.Stdout = e.console.Out()
+	c.Stderr = e.console.Error()
+	c.Env = os.Environ()
+	keys := make([]string, 0, len(cmd.Env))
+	for k := range cmd.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c.Env = append(c.Env, k+"="+cmd.Env[k])
+	}
+	return c.Run()
+}
+
+// Memory is an executor that records commands instead of running them
+type Memory interface {
+	Executor
+	Commands() []Command
+}
+
+// NewMemory creates an executor that records commands. The handler, when given, is called for each command
+// and its error is returned from Run.
+func NewMemory(handler ...func(cmd *Command) error) Memory {
+	return &memory{
+		handlers: handler,
+	}
+}
+
+type memory struct {
+	commands []Command
+	handlers []func(cmd *Command) error
+}
+
+func (m *memory) Run(cmd *Command) error {
+	m.commands = append(m.commands, *cmd)
+	for _, handler := range m.handlers {
+		err := handler(cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memory) Commands() []Command {
+	return m.commands
+}
+
+// Quote quotes the argument for the operating system shell
+func Quote(arg string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(arg, `"`, `""`) + `"`
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
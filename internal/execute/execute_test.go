@@ -0,0 +1,24 @@
+package execute_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/patrickhuber/caster/internal/execute"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	executor := execute.NewMemory(func(cmd *execute.Command) error {
+		if cmd.Script == "fail" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	require.NoError(t, executor.Run(&execute.Command{Script: "go mod tidy", Dir: "/target"}))
+	require.Error(t, executor.Run(&execute.Command{Script: "fail"}))
+	require.Equal(t, []execute.Command{
+		{Script: "go mod tidy", Dir: "/target"},
+		{Script: "fail"},
+	}, executor.Commands())
+}
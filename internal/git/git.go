@@ -0,0 +1,243 @@
+// Package git checks out templates from git repositories into the template cache
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/patrickhuber/caster/internal/cache"
+	"github.com/patrickhuber/caster/internal/execute"
+	"github.com/patrickhuber/go-xplat/filepath"
+	afs "github.com/patrickhuber/go-xplat/fs"
+)
+
+// Prefix marks a template as a git source
+const Prefix = "git::"
+
+// Source is a git repository, the subdirectory of the template and the tag, branch or commit to check out
+type Source struct {
+	URL    string
+	Subdir string
+	Ref    string
+}
+
+// IsSource returns true if the template is a git source
+func IsSource(template string) bool {
+	return strings.HasPrefix(strings.TrimSpace(template), Prefix)
+}
+
+// Parse parses a git source of the form git::<url>[//<subdir>][?ref=<ref>]
+func Parse(template string) (*Source, error) {
+	template = strings.TrimSpace(template)
+	if !IsSource(template) {
+		return nil, fmt.Errorf("'%s' is not a git source, expected the prefix '%s'", template, Prefix)
+	}
+	source := &Source{}
+	rest := strings.TrimPrefix(template, Prefix)
+
+	if index := strings.LastIndex(rest, "?"); index >= 0 {
+		query := rest[index+1:]
+		rest = rest[:index]
+		for _, pair := range strings.Split(query, "&") {
+			key, value, _ := strings.Cut(pair, "=")
+			switch key {
+			case "ref":
+				source.Ref = value
+			default:
+				return nil, fmt.Errorf("git source '%s' has unrecognized parameter '%s'", template, key)
+			}
+		}
+	}
+
+	// the subdirectory separator is the first double slash after the scheme
+	start := 0
+	if index := strings.Index(rest, "://"); index >= 0 {
+		start = index + len("://")
+	}
+	if index := strings.Index(rest[start:], "//"); index >= 0 {
+		source.Subdir = strings.Trim(rest[start+index+len("//"):], "/")
+		rest = rest[:start+index]
+	}
+
+	source.URL = rest
+	if len(source.URL) == 0 {
+		return nil, fmt.Errorf("git source '%s' is missing a url", template)
+	}
+
+	// arguments starting with a dash would be read by git as options
+	if strings.HasPrefix(source.URL, "-") {
+		return nil, fmt.Errorf("git source '%s' has a url starting with '-'", template)
+	}
+	if strings.HasPrefix(source.Ref, "-") {
+		return nil, fmt.Errorf("git source '%s' has a ref starting with '-'", template)
+	}
+
+	// the subdirectory is joined to the checkout and must stay inside of it
+	for _, segment := range strings.FieldsFunc(source.Subdir, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return nil, fmt.Errorf("git source '%s' has a subdirectory outside of the repository", template)
+		}
+	}
+	return source, nil
+}
+
+// String returns the source without the subdirectory. It identifies the source in the cache.
+func (s *Source) String() string {
+	if len(s.Ref) == 0 {
+		return Prefix + s.URL
+	}
+	return Prefix + s.URL + "?ref=" + s.Ref
+}
+
+// Service checks out git sources
+type Service interface {
+	// Checkout fetches the ref into the cache and returns the template directory of the resolved revision.
+	// When offline is set the most recently fetched revision in the cache is used.
+	Checkout(source *Source, offline bool) (string, error)
+}
+
+// NewService creates a git service that runs the git command line with the executor
+func NewService(fs afs.FS, cache cache.Service, path *filepath.Processor, executor execute.Executor) Service {
+	return &service{
+		fs:       fs,
+		cache:    cache,
+		path:     path,
+		executor: executor,
+	}
+}
+
+type service struct {
+	fs       afs.FS
+	cache    cache.Service
+	path     *filepath.Processor
+	executor execute.Executor
+}
+
+func (s *service) Checkout(source *Source, offline bool) (string, error) {
+	// commits never change so a pinned commit in the cache is used without fetching
+	if offline || commit.MatchString(source.Ref) {
+		entry, ok, err := s.cache.Find(source.String())
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return s.templateDir(entry.Key, source), nil
+		}
+		if offline {
+			return "", fmt.Errorf("git source '%s' : %w", source, cache.ErrOffline)
+		}
+	}
+
+	// a clone of each repository is kept to fetch refs
+	repository := s.path.Join(s.cache.Dir(), "git", key(source.URL))
+	exists, err := s.fs.Exists(repository)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		err = s.fs.MkdirAll(s.path.Dir(repository), 0755)
+		if err != nil {
+			return "", err
+		}
+		script := fmt.Sprintf("git clone --quiet --no-checkout --end-of-options %s %s", execute.Quote(source.URL), execute.Quote(repository))
+		err = s.run(script, s.path.Dir(repository), source)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// the ref is fetched on every checkout so cached branches are never stale
+	ref := source.Ref
+	if len(ref) == 0 {
+		ref = "HEAD"
+	}
+	err = s.run("git fetch --quiet --force --end-of-options origin "+execute.Quote(ref), repository, source)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch ref '%s' : %w", ref, err)
+	}
+	revision, err := s.fetchHead(repository)
+	if err != nil {
+		return "", err
+	}
+
+	// each revision is checked out into its own cache entry. The entry is only complete once it is recorded in the
+	// cache so a directory left by an interrupted checkout is checked out again.
+	key := cache.Key(Prefix+source.URL, revision)
+	entry, ok, err := s.cache.Find(source.String())
+	if err != nil {
+		return "", err
+	}
+	if !ok || entry.Key != key {
+		err = s.export(repository, s.cache.Path(key), source)
+		if err != nil {
+			return "", fmt.Errorf("unable to check out ref '%s' : %w", ref, err)
+		}
+	}
+
+	err = s.cache.Add(cache.Entry{
+		Source:   source.String(),
+		Revision: revision,
+		Key:      key,
+		Fetched:  time.Now().UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.templateDir(key, source), nil
+}
+
+// fetchHead returns the revision of the last fetch
+func (s *service) fetchHead(repository string) (string, error) {
+	content, err := s.fs.ReadFile(s.path.Join(repository, ".git", "FETCH_HEAD"))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unable to read the fetched revision of '%s'", repository)
+	}
+	return fields[0], nil
+}
+
+// export writes the fetched files into an empty directory
+func (s *service) export(repository, dir string, source *Source) error {
+	err := s.fs.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	err = s.fs.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf("git --work-tree %s checkout --quiet --force FETCH_HEAD -- .", execute.Quote(dir))
+	return s.run(script, repository, source)
+}
+
+func (s *service) templateDir(key string, source *Source) string {
+	dir := s.cache.Path(key)
+	if len(source.Subdir) == 0 {
+		return dir
+	}
+	return s.path.Join(dir, source.Subdir)
+}
+
+func (s *service) run(script, dir string, source *Source) error {
+	err := s.executor.Run(&execute.Command{Script: script, Dir: dir})
+	if err != nil {
+		return fmt.Errorf("git source '%s' : %w", source.URL, err)
+	}
+	return nil
+}
+
+// commit matches a full commit hash
+var commit = regexp.MustCompile("^[0-9a-fA-F]{40}$")
+
+// key returns the directory name of the repository url
+func key(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])[:16]
+}
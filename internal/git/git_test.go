@@ -0,0 +1,123 @@
+package git_test
+
+import (
+	osexec "os/exec"
+	"testing"
+
+	"github.com/patrickhuber/caster/internal/cache"
+	"github.com/patrickhuber/caster/internal/execute"
+	"github.com/patrickhuber/caster/internal/git"
+	"github.com/patrickhuber/go-xplat/console"
+	"github.com/patrickhuber/go-xplat/env"
+	"github.com/patrickhuber/go-xplat/filepath"
+	afs "github.com/patrickhuber/go-xplat/fs"
+	"github.com/patrickhuber/go-xplat/os"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	type test struct {
+		name     string
+		template string
+		expected *git.Source
+	}
+	tests := []test{
+		{"url", "git::https://example.com/templates.git", &git.Source{URL: "https://example.com/templates.git"}},
+		{"file", "git::file:///srv/templates.git//go-cli?ref=v1.2.0", &git.Source{URL: "file:///srv/templates.git", Subdir: "go-cli", Ref: "v1.2.0"}},
+		{"ssh", "git::ssh://git@example.com/org/templates.git//go/cli?ref=main", &git.Source{URL: "ssh://git@example.com/org/templates.git", Subdir: "go/cli", Ref: "main"}},
+		{"scp", "git::git@example.com:org/templates.git//go-cli", &git.Source{URL: "git@example.com:org/templates.git", Subdir: "go-cli"}},
+		{"path", "git::/srv/templates.git?ref=abc123", &git.Source{URL: "/srv/templates.git", Ref: "abc123"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := git.Parse(test.template)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, source)
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		for _, template := range []string{"/srv/templates", "git::", "git::/srv/templates.git?branch=main",
+			"git::file:///srv/templates.git?ref=--upload-pack=touch /tmp/pwned", "git::--upload-pack=touch /tmp/pwned",
+			"git::file:///srv/templates.git//../..", "git::file:///srv/templates.git//go/../../etc"} {
+			_, err := git.Parse(template)
+			require.Error(t, err, template)
+		}
+	})
+}
+
+func TestCheckout(t *testing.T) {
+	if _, err := osexec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	path := filepath.NewProcessor()
+	fs := afs.NewOS()
+	e := env.NewMemory()
+	e.Set("XDG_CACHE_HOME", t.TempDir())
+	executor := execute.NewOS(console.NewMemory())
+
+	// create a bare repository with a tag and a newer commit on the default branch
+	work := t.TempDir()
+	bare := path.Join(t.TempDir(), "templates.git")
+	run := func(script string) {
+		require.NoError(t, executor.Run(&execute.Command{Script: script, Dir: work}))
+	}
+	run("git init --quiet && git config user.email test@example.com && git config user.name test")
+	require.NoError(t, fs.MkdirAll(path.Join(work, "go-cli"), 0755))
+	require.NoError(t, fs.WriteFile(path.Join(work, "go-cli", ".caster.yml"), []byte("v1"), 0644))
+	run("git add . && git commit --quiet -m v1 && git tag v1.0.0")
+	require.NoError(t, fs.WriteFile(path.Join(work, "go-cli", ".caster.yml"), []byte("v2"), 0644))
+	run("git commit --quiet -am v2")
+	run("git clone --quiet --bare . " + execute.Quote(bare))
+
+	c := cache.NewService(fs, e, os.New(), path)
+	svc := git.NewService(fs, c, path, executor)
+	checkout := func(ref string) string {
+		dir, err := svc.Checkout(&git.Source{URL: "file://" + bare, Subdir: "go-cli", Ref: ref}, false)
+		require.NoError(t, err)
+		content, err := fs.ReadFile(path.Join(dir, ".caster.yml"))
+		require.NoError(t, err)
+		return string(content)
+	}
+	require.Equal(t, "v2", checkout(""))
+	require.Equal(t, "v1", checkout("v1.0.0"))
+
+	// the cached clone is fetched so new commits are found
+	require.NoError(t, fs.WriteFile(path.Join(work, "go-cli", ".caster.yml"), []byte("v3"), 0644))
+	run("git commit --quiet -am v3 && git push --quiet " + execute.Quote(bare) + " HEAD")
+	require.Equal(t, "v3", checkout(""))
+
+	_, err := svc.Checkout(&git.Source{URL: "file://" + bare, Ref: "missing"}, false)
+	require.Error(t, err)
+
+	// refs and urls that look like options are passed to git as arguments
+	marker := path.Join(t.TempDir(), "pwned")
+	_, err = svc.Checkout(&git.Source{URL: "file://" + bare, Ref: "--upload-pack=touch " + marker}, false)
+	require.Error(t, err)
+	_, err = svc.Checkout(&git.Source{URL: "--upload-pack=touch " + marker}, false)
+	require.Error(t, err)
+	pwned, err := fs.Exists(marker)
+	require.NoError(t, err)
+	require.False(t, pwned)
+
+	// offline checkouts and pinned commits are read from the cache after the repository is gone
+	entry, ok, err := c.Find("git::file://" + bare + "?ref=v1.0.0")
+	require.NoError(t, err)
+	require.True(t, ok)
+	v1, err := svc.Checkout(&git.Source{URL: "file://" + bare, Ref: entry.Revision}, false)
+	require.NoError(t, err)
+	require.NoError(t, fs.RemoveAll(bare))
+
+	dir, err := svc.Checkout(&git.Source{URL: "file://" + bare, Subdir: "go-cli", Ref: "v1.0.0"}, true)
+	require.NoError(t, err)
+	content, err := fs.ReadFile(path.Join(dir, ".caster.yml"))
+	require.NoError(t, err)
+	require.Equal(t, "v1", string(content))
+
+	pinned, err := svc.Checkout(&git.Source{URL: "file://" + bare, Ref: entry.Revision}, false)
+	require.NoError(t, err)
+	require.Equal(t, v1, pinned)
+
+	_, err = svc.Checkout(&git.Source{URL: "file://" + bare, Ref: "main"}, true)
+	require.ErrorIs(t, err, cache.ErrOffline)
+}
@@ -0,0 +1,191 @@
+package interpolate
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/patrickhuber/caster/internal/models"
+)
+
+// Foreach items are only known when the files and folders are planned, so actions of the caster file
+// that use a foreach alias like '{{ .item.name }}' are replaced with a marker before the caster file is
+// rendered. RenderDeferred renders the markers once the item is added to the data.
+
+var (
+	foreachPattern  = regexp.MustCompile(`(?m)(^[\s-]*foreach|"foreach")\s*:`)
+	aliasPattern    = regexp.MustCompile(`(?m)(^[\s-]*as|"as")\s*:\s*["']?([A-Za-z_][A-Za-z0-9_]*)`)
+	deferredPattern = regexp.MustCompile(`__caster_deferred_([0-9a-f]+)__`)
+)
+
+// deferForeach replaces the actions that use a foreach alias with markers. Aliases can only be used in
+// single actions, an if, range or with block using one is an error because it can not be deferred.
+func deferForeach(content string, funcs template.FuncMap) (string, error) {
+	if !foreachPattern.MatchString(content) {
+		return content, nil
+	}
+	aliases := map[string]bool{models.DefaultForeachAlias: true}
+	for _, match := range aliasPattern.FindAllStringSubmatch(content, -1) {
+		aliases[match[2]] = true
+	}
+
+	t, err := template.New("caster").Funcs(funcs).Parse(content)
+	if err != nil {
+		// parse errors are reported when the caster file is rendered
+		return content, nil
+	}
+	d := &deferrer{content: content, aliases: aliases}
+	for _, tpl := range t.Templates() {
+		if tpl.Tree == nil {
+			continue
+		}
+		err = d.walk(tpl.Tree.Root, true)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// replace from the end so the positions of earlier actions stay valid
+	sort.Slice(d.edits, func(i, j int) bool { return d.edits[i].start > d.edits[j].start })
+	for _, e := range d.edits {
+		content = content[:e.start] + e.text + content[e.end:]
+	}
+	return content, nil
+}
+
+// RenderDeferred renders the markers of deferred actions in the text with the data
+func RenderDeferred(text string, funcs template.FuncMap, data any) (string, error) {
+	if !strings.Contains(text, "__caster_deferred_") {
+		return text, nil
+	}
+	var err error
+	result := deferredPattern.ReplaceAllStringFunc(text, func(marker string) string {
+		if err != nil {
+			return marker
+		}
+		pipeline, decodeErr := hex.DecodeString(deferredPattern.FindStringSubmatch(marker)[1])
+		if decodeErr != nil {
+			err = decodeErr
+			return marker
+		}
+		var rendered strings.Builder
+		var t *template.Template
+		t, err = template.New("deferred").Funcs(funcs).Parse("{{ " + string(pipeline) + " }}")
+		if err == nil {
+			err = t.Execute(&rendered, data)
+		}
+		if err != nil {
+			err = fmt.Errorf("unable to render '{{ %s }}' : %w", pipeline, err)
+		}
+		return rendered.String()
+	})
+	return result, err
+}
+
+type edit struct {
+	start int
+	end   int
+	text  string
+}
+
+type deferrer struct {
+	content string
+	aliases map[string]bool
+	edits   []edit
+}
+
+// walk finds the actions using an alias. Dot is false inside range and with blocks where '.' is no longer the data.
+func (d *deferrer) walk(node parse.Node, dot bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			err := d.walk(child, dot)
+			if err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		if !d.references(n.Pipe, dot) {
+			return nil
+		}
+		if len(n.Pipe.Decl) > 0 {
+			return fmt.Errorf("the foreach item can not be assigned to a variable in '{{ %s }}'", n.Pipe)
+		}
+		return d.deferAction(n)
+	case *parse.IfNode:
+		return d.walkBranch("if", &n.BranchNode, dot, dot)
+	case *parse.RangeNode:
+		return d.walkBranch("range", &n.BranchNode, false, dot)
+	case *parse.WithNode:
+		return d.walkBranch("with", &n.BranchNode, false, dot)
+	}
+	return nil
+}
+
+func (d *deferrer) walkBranch(keyword string, n *parse.BranchNode, listDot, elseDot bool) error {
+	if d.references(n.Pipe, elseDot) {
+		return fmt.Errorf("the foreach item can not be used in '{{ %s %s }}', use it in a single action or filter items with when", keyword, n.Pipe)
+	}
+	err := d.walk(n.List, listDot)
+	if err != nil {
+		return err
+	}
+	return d.walk(n.ElseList, elseDot)
+}
+
+// references returns true when the node uses an alias as '.alias' or '$.alias'
+func (d *deferrer) references(node parse.Node, dot bool) bool {
+	switch n := node.(type) {
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if d.references(cmd, dot) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if d.references(arg, dot) {
+				return true
+			}
+		}
+	case *parse.ChainNode:
+		return d.references(n.Node, dot)
+	case *parse.FieldNode:
+		return dot && d.aliases[n.Ident[0]]
+	case *parse.VariableNode:
+		return len(n.Ident) > 1 && n.Ident[0] == "$" && d.aliases[n.Ident[1]]
+	}
+	return false
+}
+
+// deferAction replaces the action with an action printing the marker, keeping its trim markers
+func (d *deferrer) deferAction(n *parse.ActionNode) error {
+	pos := int(n.Position())
+	start := strings.LastIndex(d.content[:pos], "{{")
+	end := strings.Index(d.content[pos:], "}}")
+	if start < 0 || end < 0 {
+		return fmt.Errorf("unable to find the action '{{ %s }}'", n.Pipe)
+	}
+	end += pos + len("}}")
+	action := d.content[start:end]
+	left, right := "{{", "}}"
+	if strings.HasPrefix(action, "{{- ") {
+		left = "{{-"
+	}
+	if strings.HasSuffix(action, " -}}") {
+		right = "-}}"
+	}
+	marker := "__caster_deferred_" + hex.EncodeToString([]byte(n.Pipe.String())) + "__"
+	d.edits = append(d.edits, edit{start: start, end: end, text: fmt.Sprintf("%s %q %s", left, marker, right)})
+	return nil
+}
@@ -0,0 +1,271 @@
+package interpolate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/patrickhuber/caster/internal/git"
+	"github.com/patrickhuber/caster/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// casterSource is the path and content of a caster file
+type casterSource struct {
+	path    string
+	content string
+}
+
+// readExtends reads the extends key from the caster file before it is rendered
+func readExtends(content, extension string) (string, error) {
+	var caster models.Caster
+	switch extension {
+	case ".yml":
+		section := yamlSection(content, "extends")
+		if len(section) == 0 {
+			return "", nil
+		}
+		err := yaml.Unmarshal([]byte(section), &caster)
+		if err != nil {
+			return "", fmt.Errorf("unable to read extends : %w", err)
+		}
+	case ".json":
+		section := jsonSection(content, "extends")
+		if len(section) == 0 {
+			return "", nil
+		}
+		err := json.Unmarshal([]byte(section), &caster)
+		if err != nil {
+			return "", fmt.Errorf("unable to read extends : %w", err)
+		}
+	}
+	return strings.TrimSpace(caster.Extends), nil
+}
+
+// listsEntries returns true when the caster file has a files, folders or includes section before it is rendered.
+// A caster file without them is a tree. The unrendered file is used so a files section that is rendered
+// away by a false condition does not turn the template into a tree.
+func listsEntries(content, extension string) bool {
+	for _, key := range []string{"files", "folders", "includes"} {
+		switch extension {
+		case ".yml":
+			if len(yamlSection(content, key)) > 0 {
+				return true
+			}
+		case ".json":
+			if len(jsonSection(content, key)) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extendsChain returns the caster file and the caster files it extends ordered from the base template to the caster file
+func (s *service) extendsChain(path, content string) ([]casterSource, error) {
+	chain := []casterSource{{path: path, content: content}}
+	for {
+		current := chain[0]
+		extends, err := readExtends(current.content, s.path.Ext(current.path))
+		if err != nil {
+			return nil, err
+		}
+		if len(extends) == 0 {
+			return chain, nil
+		}
+		parsed, err := s.path.Parser.Parse(extends)
+		if err != nil {
+			return nil, err
+		}
+		if !parsed.IsAbs() && !git.IsSource(extends) {
+			extends = s.path.Join(s.path.Dir(current.path), extends)
+		}
+		parent, err := s.getCasterFile(&Request{Template: extends})
+		if err != nil {
+			return nil, fmt.Errorf("extends in '%s' : %w", current.path, err)
+		}
+		var paths []string
+		for _, c := range chain {
+			if c.path == parent {
+				for i := len(chain) - 1; i >= 0; i-- {
+					paths = append(paths, chain[i].path)
+				}
+				return nil, fmt.Errorf("extends cycle detected : %s -> %s", strings.Join(paths, " -> "), parent)
+			}
+		}
+		parentContent, err := s.getCasterFileContent(parent)
+		if err != nil {
+			return nil, err
+		}
+		chain = append([]casterSource{{path: parent, content: parentContent}}, chain...)
+	}
+}
+
+// readChainDeclarations reads the declarations of each caster file in the chain.
+// Declarations of a template replace the declarations with the same name in the template it extends.
+func readChainDeclarations(chain []casterSource, ext func(string) string) ([]models.Declaration, error) {
+	var declarations []models.Declaration
+	for _, c := range chain {
+		current, err := readDeclarations(c.content, ext(c.path))
+		if err != nil {
+			return nil, err
+		}
+		for _, declaration := range current {
+			replaced := false
+			for i := range declarations {
+				if declarations[i].Name == declaration.Name {
+					declarations[i] = declaration
+					replaced = true
+				}
+			}
+			if !replaced {
+				declarations = append(declarations, declaration)
+			}
+		}
+	}
+	return declarations, nil
+}
+
+// renderChain renders each caster file in the chain and merges each caster into the caster it extends.
+// When the chain has more than one caster file, every file and folder is scoped to the caster file that declared it.
+func (s *service) renderChain(chain []casterSource, data map[string]any) (*models.Caster, template.FuncMap, error) {
+	var result *models.Caster
+	var funcMap template.FuncMap
+	for i, c := range chain {
+		caster, funcs, err := s.renderCaster(c.path, c.content, data, []string{c.path})
+		if err != nil {
+			return nil, nil, err
+		}
+		funcMap = funcs
+		if len(chain) == 1 {
+			caster.Tree = caster.Tree || !listsEntries(c.content, s.path.Ext(c.path))
+			return caster, funcMap, nil
+		}
+
+		scope := &models.Scope{SourceFile: c.path, Data: data, Funcs: funcs}
+		scopeFiles(caster.Files, scope)
+		scopeFolders(caster.Folders, scope)
+		scopeHooks(caster.Hooks.PreApply, scope)
+		scopeHooks(caster.Hooks.PostApply, scope)
+
+		// tree templates are copied from the template directory into the target. A caster file that only
+		// extends its parent is not a tree unless it sets tree, only the base is a tree when it lists nothing.
+		if caster.Tree || (i == 0 && !listsEntries(c.content, s.path.Ext(c.path))) {
+			caster.Folders = append([]models.Folder{{Ref: ".", Template: true, Scope: scope}}, caster.Folders...)
+			caster.Tree = false
+		}
+
+		if result == nil {
+			result = caster
+			continue
+		}
+		result.Files = mergeFiles(result.Files, caster.Files)
+		result.Folders = mergeFolders(result.Folders, caster.Folders)
+		result.Hooks.PreApply = append(result.Hooks.PreApply, caster.Hooks.PreApply...)
+		result.Hooks.PostApply = append(result.Hooks.PostApply, caster.Hooks.PostApply...)
+		result.Variables = caster.Variables
+		result.Includes = caster.Includes
+		result.Extends = caster.Extends
+	}
+	return result, funcMap, nil
+}
+
+func scopeFiles(files []models.File, scope *models.Scope) {
+	for i := range files {
+		if files[i].Scope == nil {
+			files[i].Scope = scope
+		}
+	}
+}
+
+func scopeFolders(folders []models.Folder, scope *models.Scope) {
+	for i := range folders {
+		// folders of included templates keep their scope for their children
+		if folders[i].Scope != nil {
+			continue
+		}
+		folders[i].Scope = scope
+		scopeFiles(folders[i].Files, scope)
+		scopeFolders(folders[i].Folders, scope)
+	}
+}
+
+func scopeHooks(hooks []models.Hook, scope *models.Scope) {
+	for i := range hooks {
+		if hooks[i].Scope == nil {
+			hooks[i].Scope = scope
+		}
+	}
+}
+
+// mergeFiles replaces base files with child files of the same name, removes deleted files and appends new files
+func mergeFiles(base, child []models.File) []models.File {
+	result := append([]models.File{}, base...)
+	for _, file := range child {
+		index := -1
+		for i := range result {
+			if result[i].Name == file.Name {
+				index = i
+			}
+		}
+		switch {
+		case file.Delete && index >= 0:
+			result = append(result[:index], result[index+1:]...)
+		case file.Delete:
+		case index >= 0:
+			result[index] = file
+		default:
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// mergeFolders merges child folders into base folders of the same name, removes deleted folders and appends new folders.
+// Fields set on the child folder replace the fields of the base folder.
+func mergeFolders(base, child []models.Folder) []models.Folder {
+	result := append([]models.Folder{}, base...)
+	for _, folder := range child {
+		index := -1
+		for i := range result {
+			if len(folder.Name) > 0 && result[i].Name == folder.Name {
+				index = i
+			}
+		}
+		switch {
+		case folder.Delete && index >= 0:
+			result = append(result[:index], result[index+1:]...)
+		case folder.Delete:
+		case index >= 0:
+			result[index] = mergeFolder(result[index], folder)
+		default:
+			result = append(result, folder)
+		}
+	}
+	return result
+}
+
+func mergeFolder(base, child models.Folder) models.Folder {
+	merged := base
+	merged.Files = mergeFiles(base.Files, child.Files)
+	merged.Folders = mergeFolders(base.Folders, child.Folders)
+	if len(child.Mode) > 0 {
+		merged.Mode = child.Mode
+	}
+	if len(child.Ref) > 0 {
+		merged.Ref = child.Ref
+		merged.Include = child.Include
+		merged.Exclude = child.Exclude
+		merged.Template = child.Template
+		merged.Scope = child.Scope
+	}
+	if len(child.When) > 0 {
+		merged.When = child.When
+	}
+	if len(child.Foreach) > 0 {
+		merged.Foreach = child.Foreach
+		merged.As = child.As
+	}
+	return merged
+}
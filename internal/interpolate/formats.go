@@ -0,0 +1,254 @@
+package interpolate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/patrickhuber/caster/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// fileFormat returns the format of the variable file from the file name.
+// Files with an unrecognized extension are read as yaml.
+func fileFormat(name, extension string) string {
+	switch strings.ToLower(extension) {
+	case ".json":
+		return models.FormatJson
+	case ".toml":
+		return models.FormatToml
+	case ".env":
+		return models.FormatEnv
+	case ".properties":
+		return models.FormatProperties
+	}
+	if strings.HasPrefix(name, ".env") {
+		return models.FormatEnv
+	}
+	return models.FormatYaml
+}
+
+// decodeFile decodes the content of a variable file with the format and returns the line of each key
+func decodeFile(content []byte, format string) (map[string]any, map[string]int, error) {
+	switch format {
+	case models.FormatYaml, "":
+		data := map[string]any{}
+		err := yaml.Unmarshal(content, data)
+		return data, yamlLines(content), err
+	case models.FormatJson:
+		if !json.Valid(content) {
+			return nil, nil, fmt.Errorf("invalid json")
+		}
+		// json is valid yaml, decoding with yaml keeps whole numbers as integers
+		data := map[string]any{}
+		err := yaml.Unmarshal(content, data)
+		return data, yamlLines(content), err
+	case models.FormatToml:
+		data := map[string]any{}
+		err := toml.Unmarshal(content, &data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return normalize(data).(map[string]any), tomlLines(content), nil
+	case models.FormatEnv:
+		return decodeEnv(content)
+	case models.FormatProperties:
+		return decodeProperties(content)
+	}
+	return nil, nil, fmt.Errorf("unrecognized variable file format '%s'", format)
+}
+
+// normalize converts typed maps and lists to map[string]any and []any so they can be merged
+func normalize(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = normalize(item)
+		}
+		return v
+	case []map[string]any:
+		list := make([]any, 0, len(v))
+		for _, item := range v {
+			list = append(list, normalize(item))
+		}
+		return list
+	case []any:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+		return v
+	}
+	return value
+}
+
+// decodeEnv decodes a dotenv file with KEY=value lines. Blank lines, comments and an 'export' prefix are ignored
+// and values can be wrapped in single or double quotes.
+func decodeEnv(content []byte) (map[string]any, map[string]int, error) {
+	data := map[string]any{}
+	lines := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || len(key) == 0 {
+			return nil, nil, fmt.Errorf("line %d : expected KEY=value", line)
+		}
+		data[key] = unquote(strings.TrimSpace(value))
+		lines[key] = line
+	}
+	return data, lines, scanner.Err()
+}
+
+func unquote(value string) string {
+	if len(value) < 2 {
+		return value
+	}
+	switch {
+	case value[0] == '"' && value[len(value)-1] == '"':
+		return strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1]
+	}
+	// strip inline comments from unquoted values
+	if i := strings.Index(value, " #"); i >= 0 {
+		return strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
+// decodeProperties decodes a java properties file. Dotted keys are mapped into nested maps.
+func decodeProperties(content []byte) (map[string]any, map[string]int, error) {
+	data := map[string]any{}
+	lines := map[string]int{}
+	set := func(text string, line int) error {
+		key, value := splitProperty(text)
+		lines[trackedKey(key)] = line
+		return setProperty(data, key, value)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	var logical strings.Builder
+	start := 0
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimLeft(scanner.Text(), " \t\f")
+		if logical.Len() == 0 && (len(text) == 0 || text[0] == '#' || text[0] == '!') {
+			continue
+		}
+		if logical.Len() == 0 {
+			start = line
+		}
+		// a line ending in an odd number of backslashes continues on the next line
+		trimmed := strings.TrimRight(text, "\\")
+		if (len(text)-len(trimmed))%2 == 1 {
+			logical.WriteString(text[:len(text)-1])
+			continue
+		}
+		logical.WriteString(text)
+		err := set(logical.String(), start)
+		logical.Reset()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if logical.Len() > 0 {
+		err := set(logical.String(), start)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return data, lines, scanner.Err()
+}
+
+// setProperty sets the value at the dotted key. The last property wins when a key is both a value and the
+// parent of other keys, so 'logging.level=INFO' followed by 'logging.level.root=DEBUG' replaces the value
+// of 'logging.level' with a map and the reverse order replaces the map with the value.
+func setProperty(data map[string]any, key string, value string) error {
+	segments, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	var current any = data
+	for i := 0; i < len(segments)-1; i++ {
+		var next any
+		var clear func()
+		switch c := current.(type) {
+		case map[string]any:
+			segment, ok := segments[i].(string)
+			if !ok {
+				return setKey(data, key, value)
+			}
+			next, clear = c[segment], func() { delete(c, segment) }
+		case []any:
+			segment, ok := segments[i].(int)
+			if !ok || segment >= len(c) {
+				return setKey(data, key, value)
+			}
+			next, clear = c[segment], func() { c[segment] = nil }
+		default:
+			return setKey(data, key, value)
+		}
+		// a value of the wrong type is replaced by the container of the next segment
+		_, isMap := next.(map[string]any)
+		_, isList := next.([]any)
+		_, wantsMap := segments[i+1].(string)
+		if next != nil && !(isMap && wantsMap || isList && !wantsMap) {
+			clear()
+			break
+		}
+		current = next
+	}
+	return setKey(data, key, value)
+}
+
+// splitProperty splits the line on the first unescaped '=', ':' or whitespace
+func splitProperty(line string) (string, string) {
+	var key strings.Builder
+	i := 0
+	for ; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' && i+1 < len(line) {
+			i++
+			key.WriteByte(unescape(line[i]))
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			break
+		}
+		key.WriteByte(c)
+	}
+	rest := strings.TrimLeft(line[i:], " \t\f")
+	if len(rest) > 0 && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	var value strings.Builder
+	for j := 0; j < len(rest); j++ {
+		if rest[j] == '\\' && j+1 < len(rest) {
+			j++
+			value.WriteByte(unescape(rest[j]))
+			continue
+		}
+		value.WriteByte(rest[j])
+	}
+	return key.String(), value.String()
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 't':
+		return '\t'
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 'f':
+		return '\f'
+	}
+	return c
+}
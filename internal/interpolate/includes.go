@@ -0,0 +1,112 @@
+package interpolate
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/patrickhuber/caster/internal/git"
+	"github.com/patrickhuber/caster/internal/models"
+)
+
+// renderCaster renders the caster file with the data and resolves its includes.
+// The stack contains the caster files being rendered and is used to detect include cycles.
+func (s *service) renderCaster(path, content string, data map[string]any, stack []string) (*models.Caster, template.FuncMap, error) {
+	funcMap := s.createFuncMap(path)
+
+	rendered, err := s.renderCasterFile(content, funcMap, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caster, err := s.deserializeCasterFile(rendered, s.path.Ext(path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, include := range caster.Includes {
+		folder, hooks, err := s.include(path, &include, stack)
+		if err != nil {
+			return nil, nil, err
+		}
+		caster.Folders = append(caster.Folders, *folder)
+		caster.Hooks.PreApply = append(caster.Hooks.PreApply, hooks.PreApply...)
+		caster.Hooks.PostApply = append(caster.Hooks.PostApply, hooks.PostApply...)
+	}
+	return caster, funcMap, nil
+}
+
+// include interpolates the included template with its variables and returns a folder for the target subpath
+// and the hooks of the included template. A folder without a name is planned directly into the parent.
+func (s *service) include(parent string, include *models.Include, stack []string) (*models.Folder, *models.Hooks, error) {
+	if len(strings.TrimSpace(include.Template)) == 0 {
+		return nil, nil, fmt.Errorf("include in '%s' is missing a template", parent)
+	}
+	template := include.Template
+	parsed, err := s.path.Parser.Parse(template)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !parsed.IsAbs() && !git.IsSource(template) {
+		template = s.path.Join(s.path.Dir(parent), template)
+	}
+	path, err := s.getCasterFile(&Request{Template: template})
+	if err != nil {
+		return nil, nil, fmt.Errorf("include '%s' in '%s' : %w", include.Template, parent, err)
+	}
+	for _, p := range stack {
+		if p == path {
+			return nil, nil, fmt.Errorf("include cycle detected : %s -> %s", strings.Join(stack, " -> "), path)
+		}
+	}
+
+	content, err := s.getCasterFileContent(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	declarations, err := readDeclarations(content, s.path.Ext(path))
+	if err != nil {
+		return nil, nil, err
+	}
+	data := map[string]any{}
+	for k, v := range include.Variables {
+		data[k] = v
+	}
+	err = applyDeclarations(declarations, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("include '%s' : %w", include.Template, err)
+	}
+
+	caster, funcMap, err := s.renderCaster(path, content, data, append(stack, path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scope := &models.Scope{
+		SourceFile: path,
+		Data:       data,
+		Funcs:      funcMap,
+	}
+	folder := &models.Folder{
+		Name:    include.Target,
+		Files:   caster.Files,
+		Folders: caster.Folders,
+		Scope:   scope,
+	}
+	// tree templates are copied from the template directory
+	if caster.Tree || !listsEntries(content, s.path.Ext(path)) {
+		folder.Ref = "."
+		folder.Template = true
+	}
+
+	hooks := &caster.Hooks
+	for _, list := range [][]models.Hook{hooks.PreApply, hooks.PostApply} {
+		for i := range list {
+			list[i].Dir = s.path.Join(include.Target, list[i].Dir)
+			if list[i].Scope == nil {
+				list[i].Scope = scope
+			}
+		}
+	}
+	return folder, hooks, nil
+}
@@ -0,0 +1,156 @@
+package interpolate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/patrickhuber/caster/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// parseKey splits a key like 'regions[0].name' into map keys and list indexes
+func parseKey(key string) ([]any, error) {
+	var segments []any
+	for _, part := range strings.Split(key, ".") {
+		name := part
+		index := strings.Index(part, "[")
+		if index >= 0 {
+			name = part[:index]
+		}
+		if len(name) > 0 {
+			segments = append(segments, name)
+		} else if index != 0 || len(segments) == 0 {
+			return nil, fmt.Errorf("invalid key '%s' : empty segment", key)
+		}
+		for rest := part[len(name):]; len(rest) > 0; {
+			end := strings.Index(rest, "]")
+			if rest[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("invalid key '%s' : expected index in the format [0]", key)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid key '%s' : index '%s' is not a positive integer", key, rest[1:end])
+			}
+			segments = append(segments, i)
+			rest = rest[end+1:]
+		}
+	}
+	return segments, nil
+}
+
+// setKey sets the value at the key creating maps and lists as needed
+func setKey(data map[string]any, key string, value any) error {
+	segments, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	result, err := setSegments(data, segments, value)
+	if err != nil {
+		return fmt.Errorf("unable to set key '%s' : %w", key, err)
+	}
+	for k, v := range result.(map[string]any) {
+		data[k] = v
+	}
+	return nil
+}
+
+func setSegments(current any, segments []any, value any) (any, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+	switch segment := segments[0].(type) {
+	case string:
+		m, ok := current.(map[string]any)
+		if !ok {
+			if current != nil {
+				return nil, fmt.Errorf("'%s' is not a map", segment)
+			}
+			m = map[string]any{}
+		}
+		child, err := setSegments(m[segment], segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		m[segment] = child
+		return m, nil
+	case int:
+		list, ok := current.([]any)
+		if !ok && current != nil {
+			return nil, fmt.Errorf("[%d] is not a list", segment)
+		}
+		for len(list) <= segment {
+			list = append(list, nil)
+		}
+		child, err := setSegments(list[segment], segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		list[segment] = child
+		return list, nil
+	}
+	return nil, fmt.Errorf("unrecognized segment '%v'", segments[0])
+}
+
+// setEnv sets the decoded environment variable value at the key. Keys are nested with the EnvSeparator.
+func setEnv(data map[string]any, key string, value any) error {
+	var segments []any
+	for _, segment := range strings.Split(key, EnvSeparator) {
+		if len(segment) == 0 {
+			return fmt.Errorf("invalid key '%s' : empty segment", key)
+		}
+		segments = append(segments, segment)
+	}
+	_, err := setSegments(data, segments, value)
+	return err
+}
+
+// decodeEnvValue decodes values that parse as json or yaml, so true, 8080 or {"a": 1} become a bool, a number
+// and a map. Plain words, dates and numbers that would not round trip like 1.20 or 01234 are kept as the original string.
+func decodeEnvValue(value string) any {
+	trimmed := strings.TrimSpace(value)
+	if len(trimmed) == 0 {
+		return value
+	}
+	decoded, err := decodeValue(trimmed, models.FormatYaml)
+	if err != nil {
+		return value
+	}
+	switch v := decoded.(type) {
+	case nil, bool, map[string]any, []any:
+		return decoded
+	case int:
+		if strconv.Itoa(v) == trimmed {
+			return decoded
+		}
+	case float64:
+		if strconv.FormatFloat(v, 'f', -1, 64) == trimmed {
+			return decoded
+		}
+	}
+	// strings, timestamps and numbers that do not round trip
+	return value
+}
+
+// decodeValue decodes the value of a key value variable with the format.
+// An empty format keeps the value as a string.
+func decodeValue(value string, format string) (any, error) {
+	switch format {
+	case "":
+		return value, nil
+	case models.FormatYaml:
+		var decoded any
+		err := yaml.Unmarshal([]byte(value), &decoded)
+		return decoded, err
+	case models.FormatJson:
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("invalid json value '%s'", value)
+		}
+		// json is valid yaml, decoding with yaml keeps whole numbers as integers
+		var decoded any
+		err := yaml.Unmarshal([]byte(value), &decoded)
+		return decoded, err
+	}
+	return nil, fmt.Errorf("unrecognized variable format '%s'", format)
+}
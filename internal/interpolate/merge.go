@@ -0,0 +1,111 @@
+package interpolate
+
+import (
+	"fmt"
+	"reflect"
+)
+
+const (
+	// ListReplace replaces a list with the list from the later source
+	ListReplace = "replace"
+	// ListAppend appends the items of the later list to the earlier list
+	ListAppend = "append"
+	// ListMerge merges the items of both lists that have the same value for the merge key
+	ListMerge = "merge"
+	// DefaultListMergeKey is the key used to match list items when no key is given
+	DefaultListMergeKey = "name"
+)
+
+// merger deep merges data maps. Maps are merged key by key and lists are merged with the list strategy.
+type merger struct {
+	list string
+	key  string
+}
+
+func newMerger(list, key string) (*merger, error) {
+	if len(list) == 0 {
+		list = ListReplace
+	}
+	if len(key) == 0 {
+		key = DefaultListMergeKey
+	}
+	switch list {
+	case ListReplace, ListAppend, ListMerge:
+	default:
+		return nil, fmt.Errorf("unrecognized list merge strategy '%s', expected one of [%s, %s, %s]", list, ListReplace, ListAppend, ListMerge)
+	}
+	return &merger{list: list, key: key}, nil
+}
+
+// merge merges the source map into the destination map and returns the destination
+func (m *merger) merge(dst, src map[string]any) map[string]any {
+	for k, v := range src {
+		existing, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dst[k] = m.mergeValue(existing, v)
+	}
+	return dst
+}
+
+func (m *merger) mergeValue(dst, src any) any {
+	switch s := src.(type) {
+	case map[string]any:
+		d, ok := dst.(map[string]any)
+		if !ok {
+			return src
+		}
+		return m.merge(d, s)
+	case []any:
+		d, ok := dst.([]any)
+		if !ok {
+			return src
+		}
+		return m.mergeList(d, s)
+	}
+	return src
+}
+
+func (m *merger) mergeList(dst, src []any) []any {
+	switch m.list {
+	case ListAppend:
+		return append(dst, src...)
+	case ListMerge:
+		for _, item := range src {
+			i := m.indexOf(dst, item)
+			if i < 0 {
+				dst = append(dst, item)
+				continue
+			}
+			dst[i] = m.mergeValue(dst[i], item)
+		}
+		return dst
+	}
+	return src
+}
+
+// indexOf returns the index of the map in the list with the same merge key value as the item
+func (m *merger) indexOf(list []any, item any) int {
+	value, ok := m.keyOf(item)
+	if !ok {
+		return -1
+	}
+	for i, candidate := range list {
+		other, ok := m.keyOf(candidate)
+		if ok && reflect.DeepEqual(value, other) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *merger) keyOf(item any) (any, bool) {
+	object, ok := item.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	value, ok := object[m.key]
+	return value, ok
+}
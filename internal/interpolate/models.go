@@ -1,14 +1,43 @@
 package interpolate
 
-import "github.com/patrickhuber/caster/internal/models"
+import (
+	"text/template"
+
+	"github.com/patrickhuber/caster/internal/models"
+	afs "github.com/patrickhuber/go-xplat/fs"
+)
 
 // Request is the request object for casting a template
 type Request struct {
 	Template  string            `yaml:"omitempty"`
 	Variables []models.Variable `yaml:"omitempty"`
+	// Interactive prompts for declared variables that are missing a value
+	Interactive bool `yaml:"omitempty"`
+	// SaveVariables is the path of a variable file where prompted answers are saved
+	SaveVariables string `yaml:"omitempty"`
+	// ListMerge is the strategy for merging lists from multiple variable sources (replace|append|merge)
+	ListMerge string `yaml:"omitempty"`
+	// ListMergeKey is the key used to match list items with the merge strategy
+	ListMergeKey string `yaml:"omitempty"`
+	// EnvPrefix is the prefix trimmed from environment variable names. Defaults to DefaultEnvPrefix.
+	EnvPrefix string `yaml:"omitempty"`
+	// Offline reads git and archive templates from the cache and fails instead of fetching them
+	Offline bool `yaml:"omitempty"`
+	// Raw renders the caster file without deserializing it. Includes and extended templates are not rendered.
+	Raw bool `yaml:"omitempty"`
 }
 
 type Response struct {
 	SourceFile string        `yaml:"omitempty"`
 	Caster     models.Caster `yaml:"omitempty"`
+	// Data is the data map used to render the caster file
+	Data map[string]any `yaml:"-"`
+	// Funcs is the function map used to render the caster file
+	Funcs template.FuncMap `yaml:"-"`
+	// Explanations reports the source of every variable in the data map
+	Explanations []Explanation `yaml:"-"`
+	// FS is the file system the template was read from. Archives are read into an in memory file system.
+	FS afs.FS `yaml:"-"`
+	// Raw is the rendered caster file when the request sets Raw
+	Raw string `yaml:"-"`
 }
@@ -0,0 +1,258 @@
+package interpolate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SourceFile    = "file"
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceDefault = "default"
+	SourcePrompt  = "prompt"
+)
+
+// Source is the origin of a variable value
+type Source struct {
+	// Kind is one of file, flag, env, default or prompt
+	Kind string `yaml:"kind" json:"kind"`
+	// Name is the file path, flag key, environment variable name or declaration name
+	Name string `yaml:"name" json:"name"`
+	// Line is the line of the value in a yaml or json file
+	Line int `yaml:"line,omitempty" json:"line,omitempty"`
+}
+
+func (s Source) String() string {
+	switch {
+	case s.Line > 0:
+		return fmt.Sprintf("%s %s:%d", s.Kind, s.Name, s.Line)
+	case len(s.Name) > 0:
+		return fmt.Sprintf("%s %s", s.Kind, s.Name)
+	}
+	return s.Kind
+}
+
+// Explanation reports the source that set the final value of a key and the sources it overrode
+type Explanation struct {
+	Key       string   `yaml:"key" json:"key"`
+	Value     any      `yaml:"value" json:"value"`
+	Source    Source   `yaml:"source" json:"source"`
+	Overrides []Source `yaml:"overrides,omitempty" json:"overrides,omitempty"`
+}
+
+// tracker records the sources of each key in the order they are applied.
+// Maps are tracked key by key and lists are tracked as a single value.
+type tracker struct {
+	sources map[string][]Source
+}
+
+func newTracker() *tracker {
+	return &tracker{sources: map[string][]Source{}}
+}
+
+// record records the source for every key in the value. Lines are looked up by key.
+func (t *tracker) record(key string, value any, source Source, lines map[string]int) {
+	if m, ok := value.(map[string]any); ok && len(m) > 0 {
+		for k, v := range m {
+			t.record(joinKey(key, k), v, source, lines)
+		}
+		return
+	}
+	if line, ok := lines[key]; ok {
+		source.Line = line
+	}
+	t.sources[key] = append(t.sources[key], source)
+}
+
+// then returns a tracker with the sources of the other tracker applied after the sources of this tracker
+func (t *tracker) then(other *tracker) *tracker {
+	result := newTracker()
+	for k, v := range t.sources {
+		result.sources[k] = append(result.sources[k], v...)
+	}
+	for k, v := range other.sources {
+		result.sources[k] = append(result.sources[k], v...)
+	}
+	return result
+}
+
+// explain returns an explanation for every key in the data sorted by key.
+// Values of secret keys are masked.
+func (t *tracker) explain(data map[string]any, secrets map[string]struct{}) []Explanation {
+	var explanations []Explanation
+	walkLeaves("", data, func(key string, value any) {
+		sources := t.sources[key]
+		if len(sources) == 0 {
+			return
+		}
+		if _, ok := secrets[key]; ok {
+			value = "********"
+		}
+		last := len(sources) - 1
+		explanations = append(explanations, Explanation{
+			Key:       key,
+			Value:     value,
+			Source:    sources[last],
+			Overrides: reverse(sources[:last]),
+		})
+	})
+	sort.Slice(explanations, func(i, j int) bool {
+		return explanations[i].Key < explanations[j].Key
+	})
+	return explanations
+}
+
+func walkLeaves(key string, value any, visit func(key string, value any)) {
+	if m, ok := value.(map[string]any); ok && (len(m) > 0 || len(key) == 0) {
+		for k, v := range m {
+			walkLeaves(joinKey(key, k), v, visit)
+		}
+		return
+	}
+	visit(key, value)
+}
+
+func reverse(sources []Source) []Source {
+	var result []Source
+	for i := len(sources) - 1; i >= 0; i-- {
+		result = append(result, sources[i])
+	}
+	return result
+}
+
+func joinKey(parent, key string) string {
+	if len(parent) == 0 {
+		return key
+	}
+	return parent + "." + key
+}
+
+// trackedKey returns the key tracked for a flag key. Lists are tracked as a single value so the key
+// is truncated at the first index.
+func trackedKey(key string) string {
+	if i := strings.Index(key, "["); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// yamlLines returns the line of every key in the yaml or json content
+func yamlLines(content []byte) map[string]int {
+	lines := map[string]int{}
+	var document yaml.Node
+	if yaml.Unmarshal(content, &document) != nil || len(document.Content) == 0 {
+		return lines
+	}
+	var walk func(key string, node *yaml.Node)
+	walk = func(key string, node *yaml.Node) {
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			child := joinKey(key, node.Content[i].Value)
+			lines[child] = node.Content[i].Line
+			walk(child, node.Content[i+1])
+		}
+	}
+	walk("", document.Content[0])
+	return lines
+}
+
+// tomlLines returns the line of every key in the toml content. Keys inside array tables are not
+// returned because lists are tracked as a single value.
+func tomlLines(content []byte) map[string]int {
+	lines := map[string]int{}
+	table, inArray, multiline := "", false, ""
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(multiline) > 0 {
+			if strings.Count(text, multiline)%2 == 1 {
+				multiline = ""
+			}
+			continue
+		}
+		switch {
+		case len(text) == 0 || text[0] == '#':
+			continue
+		case strings.HasPrefix(text, "[["):
+			end := strings.Index(text, "]]")
+			if end < 0 {
+				continue
+			}
+			key := tomlKey(text[2:end])
+			if _, ok := lines[key]; !ok && len(key) > 0 {
+				lines[key] = line
+			}
+			table, inArray = key, true
+			continue
+		case text[0] == '[':
+			end := strings.Index(text, "]")
+			if end < 0 {
+				continue
+			}
+			table, inArray = tomlKey(text[1:end]), false
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		key := tomlKey(name)
+		if !ok || len(key) == 0 {
+			continue
+		}
+		if !inArray {
+			lines[joinKey(table, key)] = line
+		}
+		// values starting a multiline string continue until the closing delimiter
+		for _, delimiter := range []string{`"""`, `'''`} {
+			if strings.Count(value, delimiter)%2 == 1 {
+				multiline = delimiter
+			}
+		}
+	}
+	return lines
+}
+
+// tomlKey returns the dotted key of a bare, quoted or dotted toml key. An empty string is returned for invalid keys.
+func tomlKey(text string) string {
+	var segments []string
+	var segment strings.Builder
+	quoted := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(text[i+1:], c)
+			if end < 0 {
+				return ""
+			}
+			segment.WriteString(text[i+1 : i+1+end])
+			i += end + 1
+			quoted = true
+		case c == '.':
+			segments = append(segments, segment.String())
+			segment.Reset()
+			quoted = false
+		case c == ' ' || c == '\t':
+		case c == '_' || c == '-' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9'):
+			if quoted {
+				return ""
+			}
+			segment.WriteByte(c)
+		default:
+			return ""
+		}
+	}
+	segments = append(segments, segment.String())
+	for _, s := range segments {
+		if len(s) == 0 {
+			return ""
+		}
+	}
+	return strings.Join(segments, ".")
+}
@@ -11,33 +11,73 @@ import (
 	"text/template"
 
 	"github.com/Masterminds/sprig/v3"
+	"github.com/patrickhuber/caster/internal/archive"
+	"github.com/patrickhuber/caster/internal/git"
 	"github.com/patrickhuber/caster/internal/models"
+	"github.com/patrickhuber/caster/internal/prompt"
 	"github.com/patrickhuber/go-xplat/env"
 	"github.com/patrickhuber/go-xplat/filepath"
 	afs "github.com/patrickhuber/go-xplat/fs"
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	// DefaultEnvPrefix is the prefix of environment variables that are read as variables
+	DefaultEnvPrefix = "CASTER_VAR_"
+	// EnvSeparator separates nested keys in environment variable names
+	EnvSeparator = "__"
+)
+
 type Service interface {
 	Interpolate(req *Request) (*Response, error)
 }
 
 // NewService creates a new instance of the cast service
-func NewService(fs afs.FS, env env.Environment, path *filepath.Processor) Service {
+func NewService(fs afs.FS, env env.Environment, path *filepath.Processor, prompt prompt.Service, git git.Service, archive archive.Service) Service {
 	return &service{
-		fs:   fs,
-		env:  env,
-		path: path,
+		fs:      fs,
+		source:  fs,
+		env:     env,
+		path:    path,
+		prompt:  prompt,
+		git:     git,
+		archive: archive,
 	}
 }
 
 type service struct {
-	fs   afs.FS
-	path *filepath.Processor
-	env  env.Environment
+	fs afs.FS
+	// source is the file system the template is read from
+	source  afs.FS
+	path    *filepath.Processor
+	env     env.Environment
+	prompt  prompt.Service
+	git     git.Service
+	archive archive.Service
+	// offline reads remote templates from the cache instead of fetching them
+	offline bool
 }
 
 func (s *service) Interpolate(req *Request) (*Response, error) {
+	// each request reads templates through its own copy of the service
+	scoped := *s
+	scoped.offline = req.Offline
+	s = &scoped
+
+	// archives are read through an in memory file system
+	if archive.IsSource(req.Template) {
+		source, err := archive.Parse(req.Template)
+		if err != nil {
+			return nil, err
+		}
+		fs, dir, err := s.archive.Open(source, s.offline)
+		if err != nil {
+			return nil, err
+		}
+		copied := *req
+		copied.Template = dir
+		s.source, req = fs, &copied
+	}
 
 	path, err := s.getCasterFile(req)
 	if err != nil {
@@ -49,72 +89,139 @@ func (s *service) Interpolate(req *Request) (*Response, error) {
 		return nil, err
 	}
 
-	dataMap, err := s.createDataMap(req.Variables)
+	chain, err := s.extendsChain(path, content)
+	if err != nil {
+		return nil, err
+	}
+
+	declarations, err := readChainDeclarations(chain, s.path.Ext)
+	if err != nil {
+		return nil, err
+	}
+
+	merger, err := newMerger(req.ListMerge, req.ListMergeKey)
 	if err != nil {
 		return nil, err
 	}
 
-	rendered, err := s.renderCasterFile(content, path, dataMap)
+	dataMap, tracker, err := s.createDataMap(req, merger)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := missingDeclarations(declarations, dataMap)
+	if req.Interactive {
+		err = s.promptDeclarations(declarations, dataMap, req.SaveVariables)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, declaration := range missing {
+		if value, ok := lookup(dataMap, declaration.Name); ok {
+			tracker.record(declaration.Name, value, Source{Kind: SourcePrompt, Name: declaration.Name}, nil)
+		} else if declaration.Default != nil {
+			tracker.record(declaration.Name, declaration.Default, Source{Kind: SourceDefault, Name: declaration.Name}, nil)
+		}
+	}
+
+	err = applyDeclarations(declarations, dataMap)
 	if err != nil {
 		return nil, err
 	}
 
-	structured, err := s.deserializeCasterFile(rendered, s.path.Ext(path))
+	// raw responses are used to debug caster files that do not deserialize after rendering
+	if req.Raw {
+		funcMap := s.createFuncMap(path)
+		rendered, err := s.renderCasterFile(content, funcMap, dataMap)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{
+			SourceFile:   path,
+			Data:         dataMap,
+			Funcs:        funcMap,
+			Explanations: tracker.explain(dataMap, secretKeys(declarations)),
+			FS:           s.source,
+			Raw:          string(rendered),
+		}, nil
+	}
+
+	structured, funcMap, err := s.renderChain(chain, dataMap)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Response{
-		Caster:     *structured,
-		SourceFile: path,
+		Caster:       *structured,
+		SourceFile:   path,
+		Data:         dataMap,
+		Funcs:        funcMap,
+		Explanations: tracker.explain(dataMap, secretKeys(declarations)),
+		FS:           s.source,
 	}, nil
 }
 
 // createDataMap transforms the variable array to a map[string]any.
-// variables are applied in the specified order:
-// - files
-// - command line arguments
-// - environment variables
-func (s *service) createDataMap(variables []models.Variable) (map[string]any, error) {
+// Files and command line arguments are deep merged in the order they are given
+// and then deep merged over the environment variables. The returned tracker records the source of every key.
+func (s *service) createDataMap(req *Request, merger *merger) (map[string]any, *tracker, error) {
+	prefix := req.EnvPrefix
+	if len(prefix) == 0 {
+		prefix = DefaultEnvPrefix
+	}
 	args := map[string]any{}
 	env := map[string]any{}
-	for _, variable := range variables {
+	argTracker := newTracker()
+	envTracker := newTracker()
+	for _, variable := range req.Variables {
 		isEnvVar := len(strings.TrimSpace(variable.Env)) > 0
 		isArg := len(strings.TrimSpace(variable.Key)) > 0
 		isFile := len(strings.TrimSpace(variable.File)) > 0
 		if isArg {
-			args[variable.Key] = variable.Value
+			value, err := decodeValue(variable.Value, variable.Format)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to decode variable '%s' : %w", variable.Key, err)
+			}
+			err = setKey(args, variable.Key, value)
+			if err != nil {
+				return nil, nil, err
+			}
+			key := trackedKey(variable.Key)
+			if key != variable.Key {
+				value, _ = lookup(args, key)
+			}
+			argTracker.record(key, value, Source{Kind: SourceFlag, Name: variable.Key}, nil)
 		}
 		if isEnvVar {
-			key := strings.TrimPrefix(variable.Env, "CASTER_VAR_")
-			env[key] = s.env.Get(variable.Env)
+			key := strings.TrimPrefix(variable.Env, prefix)
+			value := decodeEnvValue(s.env.Get(variable.Env))
+			err := setEnv(env, key, value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to read environment variable '%s' : %w", variable.Env, err)
+			}
+			envTracker.record(strings.ReplaceAll(key, EnvSeparator, "."), value, Source{Kind: SourceEnv, Name: variable.Env}, nil)
 		}
 		if isFile {
 			content, err := s.fs.ReadFile(variable.File)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
-			file := map[string]any{}
-			err = yaml.Unmarshal(content, file)
+			format := variable.Format
+			if len(format) == 0 {
+				format = fileFormat(s.path.Base(variable.File), s.path.Ext(variable.File))
+			}
+			file, lines, err := decodeFile(content, format)
 			if err != nil {
-				return nil, err
+				return nil, nil, fmt.Errorf("unable to read variable file '%s' : %w", variable.File, err)
 			}
 			for k, v := range file {
-				args[k] = v
+				argTracker.record(k, v, Source{Kind: SourceFile, Name: variable.File}, lines)
 			}
+			merger.merge(args, file)
 		}
 	}
-	data := map[string]any{}
-
-	for k, v := range env {
-		data[k] = v
-	}
-
-	for k, v := range args {
-		data[k] = v
-	}
-	return data, nil
+	return merger.merge(env, args), envTracker.then(argTracker), nil
 }
 
 func (s *service) readDirRegex(dir string, regex string) ([]fs.DirEntry, error) {
@@ -123,7 +230,7 @@ func (s *service) readDirRegex(dir string, regex string) ([]fs.DirEntry, error)
 	if err != nil {
 		return nil, err
 	}
-	files, err := s.fs.ReadDir(dir)
+	files, err := s.source.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -144,6 +251,18 @@ func (s *service) getCasterFile(req *Request) (string, error) {
 		return "", fmt.Errorf("template file is missing")
 	}
 
+	// git sources are checked out into the cache and read from there
+	if git.IsSource(template) {
+		source, err := git.Parse(template)
+		if err != nil {
+			return "", err
+		}
+		template, err = s.git.Checkout(source, s.offline)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	// look for relative paths
 	abs, err := s.path.Abs(template)
 	if err != nil {
@@ -152,7 +271,7 @@ func (s *service) getCasterFile(req *Request) (string, error) {
 	template = abs
 
 	// is this a file or directory?
-	info, err := s.fs.Stat(template)
+	info, err := s.source.Stat(template)
 	if errors.Is(err, fs.ErrNotExist) {
 		return "", fmt.Errorf("file %s does not exist", template)
 	}
@@ -179,14 +298,15 @@ func (s *service) getCasterFile(req *Request) (string, error) {
 }
 
 func (s *service) getCasterFileContent(path string) (string, error) {
-	content, err := s.fs.ReadFile(path)
+	content, err := s.source.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
 	return string(content), nil
 }
 
-func (s *service) renderCasterFile(content, sourceFile string, data map[string]interface{}) ([]byte, error) {
+// createFuncMap creates the function map used to render the caster file and any file relative to it
+func (s *service) createFuncMap(sourceFile string) template.FuncMap {
 
 	// inject the standard functions defined in sprig
 	funcMap := sprig.TxtFuncMap()
@@ -194,7 +314,7 @@ func (s *service) renderCasterFile(content, sourceFile string, data map[string]i
 	// templatefile renders a template file and then writes the rendered string to the calling template
 	funcMap["templatefile"] = func(path string, data interface{}) (string, error) {
 		directory := s.path.Dir(sourceFile)
-		content, err := s.fs.ReadFile(s.path.Join(directory, path))
+		content, err := s.source.ReadFile(s.path.Join(directory, path))
 		if err != nil {
 			return "", err
 		}
@@ -209,6 +329,16 @@ func (s *service) renderCasterFile(content, sourceFile string, data map[string]i
 		err = t.Execute(&writer, data)
 		return writer.String(), err
 	}
+	return funcMap
+}
+
+func (s *service) renderCasterFile(content string, funcMap template.FuncMap, data map[string]interface{}) ([]byte, error) {
+
+	// foreach items are rendered when the entries are planned
+	content, err := deferForeach(content, funcMap)
+	if err != nil {
+		return nil, err
+	}
 
 	// parse the template
 	t, err := template.
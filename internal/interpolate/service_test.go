@@ -1,12 +1,20 @@
 package interpolate_test
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/patrickhuber/caster/internal/archive"
+	"github.com/patrickhuber/caster/internal/cache"
+	"github.com/patrickhuber/caster/internal/execute"
+	"github.com/patrickhuber/caster/internal/git"
 	"github.com/patrickhuber/caster/internal/interpolate"
 	"github.com/patrickhuber/caster/internal/models"
+	"github.com/patrickhuber/caster/internal/prompt"
+	"github.com/patrickhuber/go-xplat/console"
 	"github.com/patrickhuber/go-xplat/env"
 	"github.com/patrickhuber/go-xplat/filepath"
 	afs "github.com/patrickhuber/go-xplat/fs"
@@ -15,10 +23,11 @@ import (
 )
 
 type ServiceTestContext struct {
-	fs   afs.FS
-	e    env.Environment
-	svc  interpolate.Service
-	path *filepath.Processor
+	fs      afs.FS
+	e       env.Environment
+	console console.Memory
+	svc     interpolate.Service
+	path    *filepath.Processor
 }
 
 func TestService(t *testing.T) {
@@ -72,6 +81,585 @@ files:
 		require.Equal(t, "value", file.Content)
 		require.Equal(t, "test.txt", file.Name)
 	})
+	t.Run("variables", func(t *testing.T) {
+		template := `---
+variables:
+- name: module
+  type: string
+  required: true
+  validation:
+    regex: ^github.com/
+- name: port
+  type: int
+  default: 8080
+  validation:
+    min: 1
+    max: 65535
+- name: enabled
+  type: bool
+- name: license
+  default: mit
+  validation:
+    enum: [mit, apache]
+files:
+- name: {{ .license }}.txt
+  content: {{ .module }} {{ .port }} {{ .enabled }}
+`
+		type test struct {
+			name      string
+			variables []models.Variable
+			content   string
+			err       bool
+		}
+		tests := []test{
+			{"defaults", []models.Variable{
+				{Key: "module", Value: "github.com/test"},
+			}, "github.com/test 8080 <no value>", false},
+			{"converts", []models.Variable{
+				{Key: "module", Value: "github.com/test"},
+				{Key: "port", Value: "5432"},
+				{Key: "enabled", Value: "true"},
+			}, "github.com/test 5432 true", false},
+			{"required", nil, "", true},
+			{"regex", []models.Variable{
+				{Key: "module", Value: "gitlab.com/test"},
+			}, "", true},
+			{"max", []models.Variable{
+				{Key: "module", Value: "github.com/test"},
+				{Key: "port", Value: "70000"},
+			}, "", true},
+			{"type", []models.Variable{
+				{Key: "module", Value: "github.com/test"},
+				{Key: "enabled", Value: "maybe"},
+			}, "", true},
+			{"enum", []models.Variable{
+				{Key: "module", Value: "github.com/test"},
+				{Key: "license", Value: "gpl"},
+			}, "", true},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				cx := CreateServiceTestContext(t)
+				err := cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600)
+				require.NoError(t, err)
+
+				resp, err := cx.svc.Interpolate(&interpolate.Request{
+					Template:  "/template",
+					Variables: test.variables,
+				})
+				if test.err {
+					require.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, 1, len(resp.Caster.Files))
+				require.Equal(t, "mit.txt", resp.Caster.Files[0].Name)
+				require.Equal(t, test.content, resp.Caster.Files[0].Content)
+				require.Equal(t, 4, len(resp.Caster.Variables))
+			})
+		}
+	})
+	t.Run("required errors are reported together", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		template := `variables:
+- name: first
+  required: true
+- name: second
+  required: true
+  description: the second variable
+files: []`
+		err := cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600)
+		require.NoError(t, err)
+
+		_, err = cx.svc.Interpolate(&interpolate.Request{Template: "/template"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "'first' is required")
+		require.Contains(t, err.Error(), "'second' is required (the second variable)")
+	})
+	t.Run("defaults are converted and validated", func(t *testing.T) {
+		type test struct {
+			name        string
+			declaration string
+			want        any
+			err         string
+		}
+		tests := []test{
+			{"converts", "type: int\n  default: \"80\"", 80, ""},
+			{"type", "type: int\n  default: \"80x\"\n  validation:\n    enum: [1]", nil, "variable 'port' default must be an int"},
+			{"enum", "type: int\n  default: 80\n  validation:\n    enum: [1]", nil, "variable 'port' default value '80' must be one of [1]"},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				cx := CreateServiceTestContext(t)
+				template := "variables:\n- name: port\n  " + test.declaration + "\nfiles: []"
+				require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600))
+
+				resp, err := cx.svc.Interpolate(&interpolate.Request{Template: "/template"})
+				if len(test.err) > 0 {
+					require.Error(t, err)
+					require.Contains(t, err.Error(), test.err)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, test.want, resp.Data["port"])
+			})
+		}
+	})
+	t.Run("json declarations are read around template actions", func(t *testing.T) {
+		template := `{
+  "variables": [{"name": "module", "required": true, "description": "the go module"}],
+  "files": [{{ if .docs }}{"name": "docs.md"},{{ end }}{"name": "go.mod", "content": "module {{ .module }}"}]
+}`
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.json", []byte(template), 0600))
+
+		_, err := cx.svc.Interpolate(&interpolate.Request{Template: "/template"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "'module' is required")
+
+		resp, err := cx.svc.Interpolate(&interpolate.Request{
+			Template:  "/template",
+			Variables: []models.Variable{{Key: "module", Value: "github.com/test"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(resp.Caster.Files))
+		require.Equal(t, "module github.com/test", resp.Caster.Files[0].Content)
+	})
+	t.Run("json declarations with template actions are errors", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		template := `{"variables": [{{ .declarations }}], "files": []}`
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.json", []byte(template), 0600))
+
+		_, err := cx.svc.Interpolate(&interpolate.Request{Template: "/template"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to read variables section")
+	})
+	t.Run("nested and typed variables", func(t *testing.T) {
+		type test struct {
+			name      string
+			variables []models.Variable
+			content   string
+			err       bool
+		}
+		tests := []test{
+			{"first_equals", []models.Variable{
+				{Key: "value", Value: "a=b"},
+			}, "a=b", false},
+			{"dotted", []models.Variable{
+				{Key: "db.port", Value: "5432"},
+			}, "5432", false},
+			{"indexed", []models.Variable{
+				{Key: "regions[1].name", Value: "eastus"},
+			}, "[<nil> map[name:eastus]]", false},
+			{"yaml", []models.Variable{
+				{Key: "enabled", Value: "true", Format: models.FormatYaml},
+			}, "bool", false},
+			{"json", []models.Variable{
+				{Key: "tags", Value: `["a","b"]`, Format: models.FormatJson},
+			}, "[a b]", false},
+			{"invalid_json", []models.Variable{
+				{Key: "tags", Value: `[a`, Format: models.FormatJson},
+			}, "", true},
+			{"invalid_index", []models.Variable{
+				{Key: "regions[a]", Value: "eastus"},
+			}, "", true},
+			{"not_a_map", []models.Variable{
+				{Key: "db", Value: "postgres"},
+				{Key: "db.port", Value: "5432"},
+			}, "", true},
+		}
+		templates := map[string]string{
+			"first_equals": "{{ .value }}",
+			"dotted":       "{{ .db.port }}",
+			"indexed":      "{{ .regions }}",
+			"yaml":         "{{ kindOf .enabled }}",
+			"json":         "{{ .tags }}",
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				cx := CreateServiceTestContext(t)
+				template := "files:\n- name: test.txt\n  content: '" + templates[test.name] + "'"
+				err := cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600)
+				require.NoError(t, err)
+
+				resp, err := cx.svc.Interpolate(&interpolate.Request{
+					Template:  "/template",
+					Variables: test.variables,
+				})
+				if test.err {
+					require.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, test.content, resp.Caster.Files[0].Content)
+			})
+		}
+	})
+	t.Run("variable files are deep merged", func(t *testing.T) {
+		base := `db:
+  host: localhost
+  port: 5432
+servers:
+- name: web
+  port: 80
+- name: api
+  port: 8080`
+		prod := `db:
+  host: prod
+servers:
+- name: api
+  port: 9090
+- name: worker`
+		type test struct {
+			name    string
+			list    string
+			servers string
+			err     bool
+		}
+		tests := []test{
+			{"replace", interpolate.ListReplace, "[map[name:api port:9090] map[name:worker]]", false},
+			{"default", "", "[map[name:api port:9090] map[name:worker]]", false},
+			{"append", interpolate.ListAppend, "[map[name:web port:80] map[name:api port:8080] map[name:api port:9090] map[name:worker]]", false},
+			{"merge", interpolate.ListMerge, "[map[name:web port:80] map[name:api port:9090] map[name:worker]]", false},
+			{"invalid", "zip", "", true},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				cx := CreateServiceTestContext(t)
+				require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("files: []"), 0600))
+				require.NoError(t, cx.fs.WriteFile("/base.yml", []byte(base), 0600))
+				require.NoError(t, cx.fs.WriteFile("/prod.yml", []byte(prod), 0600))
+
+				resp, err := cx.svc.Interpolate(&interpolate.Request{
+					Template:  "/template",
+					Variables: []models.Variable{{File: "/base.yml"}, {File: "/prod.yml"}},
+					ListMerge: test.list,
+				})
+				if test.err {
+					require.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, map[string]any{"host": "prod", "port": 5432}, resp.Data["db"])
+				require.Equal(t, test.servers, fmt.Sprint(resp.Data["servers"]))
+			})
+		}
+	})
+	t.Run("variable file formats", func(t *testing.T) {
+		type test struct {
+			path     string
+			content  string
+			format   string
+			template string
+		}
+		tests := []test{
+			{"/vars.yml", "db:\n  port: 5432\nname: test", "", "{{ .name }} {{ .db.port }}"},
+			{"/vars.json", `{"db": {"port": 5432}, "name": "test"}`, "", "{{ .name }} {{ .db.port }}"},
+			{"/vars.toml", "name = \"test\"\n[db]\nport = 5432", "", "{{ .name }} {{ .db.port }}"},
+			{"/.env", "# comment\nexport name=\"test\"\ndb='5432'", "", "{{ .name }} {{ .db }}"},
+			{"/vars.properties", "! comment\nname = te\\\n  st\ndb.port: 5432", "", "{{ .name }} {{ .db.port }}"},
+			{"/config", "db.port=5432\nname=test", models.FormatProperties, "{{ .name }} {{ .db.port }}"},
+		}
+		for _, test := range tests {
+			t.Run(test.path, func(t *testing.T) {
+				cx := CreateServiceTestContext(t)
+				require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("files:\n- name: test.txt\n  content: '"+test.template+"'"), 0600))
+				require.NoError(t, cx.fs.WriteFile(test.path, []byte(test.content), 0600))
+
+				resp, err := cx.svc.Interpolate(&interpolate.Request{
+					Template:  "/template",
+					Variables: []models.Variable{{File: test.path, Format: test.format}},
+				})
+				require.NoError(t, err)
+				require.Equal(t, "test 5432", resp.Caster.Files[0].Content)
+			})
+		}
+	})
+	t.Run("properties keys that are values and parents use the last property", func(t *testing.T) {
+		type test struct {
+			name    string
+			content string
+			want    map[string]any
+		}
+		tests := []test{
+			{"parent_last", "logging.level=INFO\nlogging.level.root=DEBUG", map[string]any{"logging": map[string]any{"level": map[string]any{"root": "DEBUG"}}}},
+			{"value_last", "logging.level.root=DEBUG\nlogging.level=INFO", map[string]any{"logging": map[string]any{"level": "INFO"}}},
+			{"list", "items=a\nitems[0]=b", map[string]any{"items": []any{"b"}}},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				cx := CreateServiceTestContext(t)
+				require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("files: []"), 0600))
+				require.NoError(t, cx.fs.WriteFile("/vars.properties", []byte(test.content), 0600))
+
+				resp, err := cx.svc.Interpolate(&interpolate.Request{
+					Template:  "/template",
+					Variables: []models.Variable{{File: "/vars.properties"}},
+				})
+				require.NoError(t, err)
+				require.Equal(t, test.want, resp.Data)
+			})
+		}
+	})
+	t.Run("environment variables are nested and values are decoded", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("files: []"), 0600))
+		cx.e.Set("APP_db__port", "5432")
+		cx.e.Set("APP_db__host", "localhost")
+		cx.e.Set("APP_tags", `["a", "b"]`)
+		cx.e.Set("APP_enabled", "true")
+		cx.e.Set("APP_name", "hello world")
+		cx.e.Set("APP_goversion", "1.20")
+		cx.e.Set("APP_zip", "01234")
+		cx.e.Set("APP_ratio", "0.5")
+		cx.e.Set("APP_feature__enabled", "false")
+		cx.e.Set("APP_empty", "null")
+
+		resp, err := cx.svc.Interpolate(&interpolate.Request{
+			Template: "/template",
+			Variables: []models.Variable{
+				{Env: "APP_db__host"},
+				{Env: "APP_db__port"},
+				{Env: "APP_enabled"},
+				{Env: "APP_goversion"},
+				{Env: "APP_name"},
+				{Env: "APP_tags"},
+				{Env: "APP_zip"},
+				{Env: "APP_ratio"},
+				{Env: "APP_feature__enabled"},
+				{Env: "APP_empty"},
+			},
+			EnvPrefix: "APP_",
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{
+			"db":        map[string]any{"host": "localhost", "port": 5432},
+			"empty":     nil,
+			"enabled":   true,
+			"feature":   map[string]any{"enabled": false},
+			"goversion": "1.20",
+			"name":      "hello world",
+			"ratio":     0.5,
+			"tags":      []any{"a", "b"},
+			"zip":       "01234",
+		}, resp.Data)
+	})
+	t.Run("explanations report the source of each variable", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		template := `variables:
+- name: port
+  type: int
+  default: 8080
+- name: token
+  secret: true
+files: []`
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600))
+		require.NoError(t, cx.fs.WriteFile("/base.yml", []byte("db:\n  host: localhost\n  user: admin\ntoken: abc"), 0600))
+		require.NoError(t, cx.fs.WriteFile("/prod.json", []byte("{\n  \"db\": {\n    \"host\": \"prod\"\n  }\n}"), 0600))
+		cx.e.Set("CASTER_VAR_db__host", "env")
+
+		resp, err := cx.svc.Interpolate(&interpolate.Request{
+			Template: "/template",
+			Variables: []models.Variable{
+				{File: "/base.yml"},
+				{Key: "db.user", Value: "root"},
+				{File: "/prod.json"},
+				{Env: "CASTER_VAR_db__host"},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []interpolate.Explanation{
+			{
+				Key:    "db.host",
+				Value:  "prod",
+				Source: interpolate.Source{Kind: interpolate.SourceFile, Name: "/prod.json", Line: 3},
+				Overrides: []interpolate.Source{
+					{Kind: interpolate.SourceFile, Name: "/base.yml", Line: 2},
+					{Kind: interpolate.SourceEnv, Name: "CASTER_VAR_db__host"},
+				},
+			},
+			{
+				Key:       "db.user",
+				Value:     "root",
+				Source:    interpolate.Source{Kind: interpolate.SourceFlag, Name: "db.user"},
+				Overrides: []interpolate.Source{{Kind: interpolate.SourceFile, Name: "/base.yml", Line: 3}},
+			},
+			{
+				Key:    "port",
+				Value:  8080,
+				Source: interpolate.Source{Kind: interpolate.SourceDefault, Name: "port"},
+			},
+			{
+				Key:    "token",
+				Value:  "********",
+				Source: interpolate.Source{Kind: interpolate.SourceFile, Name: "/base.yml", Line: 4},
+			},
+		}, resp.Explanations)
+	})
+	t.Run("explanations report lines of toml, dotenv and properties files", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("files: []"), 0600))
+		toml := "# comment\nname = \"toml\"\ndescription = \"\"\"\nport = 1\n\"\"\"\n[db]\nport = 5432\n[[servers]]\nhost = \"a\""
+		require.NoError(t, cx.fs.WriteFile("/vars.toml", []byte(toml), 0600))
+		require.NoError(t, cx.fs.WriteFile("/.env", []byte("\n# comment\nuser=env"), 0600))
+		require.NoError(t, cx.fs.WriteFile("/vars.properties", []byte("! comment\nlevel = te\\\n  st\nlogging.file=out.log"), 0600))
+
+		resp, err := cx.svc.Interpolate(&interpolate.Request{
+			Template: "/template",
+			Variables: []models.Variable{
+				{File: "/vars.toml"},
+				{File: "/.env"},
+				{File: "/vars.properties"},
+			},
+		})
+		require.NoError(t, err)
+		lines := map[string]interpolate.Source{}
+		for _, explanation := range resp.Explanations {
+			lines[explanation.Key] = explanation.Source
+		}
+		require.Equal(t, map[string]interpolate.Source{
+			"name":         {Kind: interpolate.SourceFile, Name: "/vars.toml", Line: 2},
+			"description":  {Kind: interpolate.SourceFile, Name: "/vars.toml", Line: 3},
+			"db.port":      {Kind: interpolate.SourceFile, Name: "/vars.toml", Line: 7},
+			"servers":      {Kind: interpolate.SourceFile, Name: "/vars.toml", Line: 8},
+			"user":         {Kind: interpolate.SourceFile, Name: "/.env", Line: 3},
+			"level":        {Kind: interpolate.SourceFile, Name: "/vars.properties", Line: 2},
+			"logging.file": {Kind: interpolate.SourceFile, Name: "/vars.properties", Line: 4},
+		}, lines)
+	})
+	t.Run("include cycles are detected", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.fs.MkdirAll("/other", 0600))
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("includes:\n- template: ../other"), 0600))
+		require.NoError(t, cx.fs.WriteFile("/other/.caster.yml", []byte("includes:\n- template: ../template"), 0600))
+
+		_, err := cx.svc.Interpolate(&interpolate.Request{Template: "/template"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "include cycle detected : /template/.caster.yml -> /other/.caster.yml -> /template/.caster.yml")
+	})
+	t.Run("git sources are checked out", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.e.Set("XDG_CACHE_HOME", "/cache"))
+		revision := "0123456789abcdef0123456789abcdef01234567"
+		executor := execute.NewMemory(func(cmd *execute.Command) error {
+			switch {
+			case strings.HasPrefix(cmd.Script, "git fetch"):
+				require.NoError(t, cx.fs.MkdirAll(cx.path.Join(cmd.Dir, ".git"), 0755))
+				return cx.fs.WriteFile(cx.path.Join(cmd.Dir, ".git", "FETCH_HEAD"), []byte(revision+"\t\ttag 'v1.2.0'"), 0600)
+			case strings.HasPrefix(cmd.Script, "git --work-tree"):
+				// the work tree is the quoted first argument
+				dir := strings.Split(cmd.Script, "'")[1]
+				require.NoError(t, cx.fs.MkdirAll(cx.path.Join(dir, "go-cli"), 0755))
+				return cx.fs.WriteFile(cx.path.Join(dir, "go-cli", ".caster.yml"), []byte("files:\n- name: main.go"), 0600)
+			}
+			return nil
+		})
+		c := cache.NewService(cx.fs, cx.e, os.NewMock(), cx.path)
+		svc := interpolate.NewService(cx.fs, cx.e, cx.path, prompt.NewService(cx.console), git.NewService(cx.fs, c, cx.path, executor), archive.NewService(cx.fs, cx.path, c))
+
+		template := "git::file:///srv/templates.git//go-cli?ref=v1.2.0"
+		resp, err := svc.Interpolate(&interpolate.Request{Template: template})
+		require.NoError(t, err)
+		require.Equal(t, "main.go", resp.Caster.Files[0].Name)
+		require.True(t, strings.HasPrefix(resp.SourceFile, "/cache/caster/templates/"))
+
+		commands := executor.Commands()
+		require.Equal(t, 3, len(commands))
+		require.Contains(t, commands[0].Script, "git clone --quiet --no-checkout --end-of-options 'file:///srv/templates.git'")
+		require.Equal(t, "git fetch --quiet --force --end-of-options origin 'v1.2.0'", commands[1].Script)
+		require.Contains(t, commands[2].Script, "checkout --quiet --force FETCH_HEAD -- .")
+
+		entries, err := c.List()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(entries))
+		require.Equal(t, "git::file:///srv/templates.git?ref=v1.2.0", entries[0].Source)
+		require.Equal(t, revision, entries[0].Revision)
+
+		// offline applies read the cached revision without running git
+		offline, err := svc.Interpolate(&interpolate.Request{Template: template, Offline: true})
+		require.NoError(t, err)
+		require.Equal(t, resp.SourceFile, offline.SourceFile)
+		require.Equal(t, 3, len(executor.Commands()))
+
+		_, err = svc.Interpolate(&interpolate.Request{Template: "git::file:///srv/templates.git?ref=main", Offline: true})
+		require.ErrorIs(t, err, cache.ErrOffline)
+	})
+	t.Run("extends cycles are detected", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.fs.MkdirAll("/other", 0600))
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("extends: ../other"), 0600))
+		require.NoError(t, cx.fs.WriteFile("/other/.caster.yml", []byte("extends: ../template"), 0600))
+
+		_, err := cx.svc.Interpolate(&interpolate.Request{Template: "/template"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "extends cycle detected : /template/.caster.yml -> /other/.caster.yml -> /template/.caster.yml")
+	})
+	t.Run("extends inherits declarations", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.fs.MkdirAll("/base", 0600))
+		require.NoError(t, cx.fs.WriteFile("/base/.caster.yml", []byte("variables:\n- name: name\n  default: base\nfiles:\n- name: base.txt\n  content: '{{ .name }}'"), 0600))
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("extends: /base\nfiles:\n- name: child.txt\n  content: '{{ .name }}'"), 0600))
+
+		resp, err := cx.svc.Interpolate(&interpolate.Request{Template: "/template"})
+		require.NoError(t, err)
+		require.Equal(t, 2, len(resp.Caster.Files))
+		require.Equal(t, "base", resp.Caster.Files[0].Content)
+		require.Equal(t, "base", resp.Caster.Files[1].Content)
+	})
+	t.Run("includes are merged into folders", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		require.NoError(t, cx.fs.MkdirAll("/component", 0600))
+		require.NoError(t, cx.fs.WriteFile("/template/.caster.yml", []byte("includes:\n- template: /component\n  target: sub\n  variables:\n    name: {{ .name }}"), 0600))
+		require.NoError(t, cx.fs.WriteFile("/component/.caster.yml", []byte("files:\n- name: '{{ .name }}.txt'"), 0600))
+
+		resp, err := cx.svc.Interpolate(&interpolate.Request{
+			Template:  "/template",
+			Variables: []models.Variable{{Key: "name", Value: "test"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(resp.Caster.Folders))
+		folder := resp.Caster.Folders[0]
+		require.Equal(t, "sub", folder.Name)
+		require.Equal(t, "test.txt", folder.Files[0].Name)
+		require.Equal(t, "/component/.caster.yml", folder.Scope.SourceFile)
+	})
+	t.Run("interactive prompts for missing variables", func(t *testing.T) {
+		cx := CreateServiceTestContext(t)
+		template := `variables:
+- name: module
+  required: true
+- name: port
+  type: int
+  default: 8080
+- name: token
+  secret: true
+- name: license
+  validation:
+    enum: [mit, apache]
+files:
+- name: test.txt
+  content: {{ .module }} {{ .port }} {{ .token }} {{ .license }} {{ .name }}`
+		err := cx.fs.WriteFile("/template/.caster.yml", []byte(template), 0600)
+		require.NoError(t, err)
+
+		// module, port (default), token, license
+		cx.console.InBuffer().WriteString("github.com/test\n\nsecret\n2\n")
+		resp, err := cx.svc.Interpolate(&interpolate.Request{
+			Template:      "/template",
+			Variables:     []models.Variable{{Key: "name", Value: "test"}},
+			Interactive:   true,
+			SaveVariables: "/answers.yml",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "github.com/test 8080 secret apache test", resp.Caster.Files[0].Content)
+
+		saved, err := cx.fs.ReadFile("/answers.yml")
+		require.NoError(t, err)
+		require.Contains(t, string(saved), "module: github.com/test")
+		require.Contains(t, string(saved), "license: apache")
+		require.NotContains(t, string(saved), "secret")
+		require.NotContains(t, string(saved), "name: test")
+	})
 }
 
 func CreateServiceTestContext(t *testing.T) *ServiceTestContext {
@@ -81,11 +669,14 @@ func CreateServiceTestContext(t *testing.T) *ServiceTestContext {
 	require.NoError(t, fs.Mkdir("/", 0600))
 	require.NoError(t, fs.Mkdir("/template", 0600))
 	e := env.NewMemory()
-	svc := interpolate.NewService(fs, e, path)
+	con := console.NewMemory()
+	c := cache.NewService(fs, e, o, path)
+	svc := interpolate.NewService(fs, e, path, prompt.NewService(con), git.NewService(fs, c, path, execute.NewMemory()), archive.NewService(fs, path, c))
 	return &ServiceTestContext{
-		fs:   fs,
-		path: path,
-		e:    e,
-		svc:  svc,
+		fs:      fs,
+		path:    path,
+		e:       e,
+		console: con,
+		svc:     svc,
 	}
 }
@@ -0,0 +1,426 @@
+package interpolate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/patrickhuber/caster/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// readDeclarations reads the variable declarations from the caster file before it is rendered.
+// The variables section of a yaml or json caster file is read on its own so the rest of the file may
+// contain template actions. The variables section itself must not contain template actions.
+func readDeclarations(content, extension string) ([]models.Declaration, error) {
+	var caster models.Caster
+	switch extension {
+	case ".yml":
+		section := yamlSection(content, "variables")
+		if len(section) == 0 {
+			return nil, nil
+		}
+		err := yaml.Unmarshal([]byte(section), &caster)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read variables section : %w", err)
+		}
+	case ".json":
+		section := jsonSection(content, "variables")
+		if len(section) == 0 {
+			return nil, nil
+		}
+		err := json.Unmarshal([]byte(section), &caster)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read variables section : %w", err)
+		}
+	}
+	return caster.Variables, nil
+}
+
+// yamlSection returns the lines of the top level key and its value
+func yamlSection(content, key string) string {
+	var section strings.Builder
+	inSection := false
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inSection {
+			if isTopLevelKey(line) {
+				break
+			}
+			section.WriteString(line)
+			section.WriteString("\n")
+			continue
+		}
+		if strings.HasPrefix(line, key+":") {
+			inSection = true
+			section.WriteString(line)
+			section.WriteString("\n")
+		}
+	}
+	return section.String()
+}
+
+// jsonSection returns a json object with the top level key and its value. Template actions outside
+// of json strings are skipped because json caster files with template actions are not valid json until rendered.
+func jsonSection(content, key string) string {
+	depth := 0
+	name, value := "", -1
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case strings.HasPrefix(content[i:], "{{"):
+			end := strings.Index(content[i:], "}}")
+			if end < 0 {
+				return ""
+			}
+			i += end + 1
+		case c == '"':
+			end := jsonStringEnd(content, i)
+			if end < 0 {
+				return ""
+			}
+			if depth == 1 && value < 0 && strings.HasPrefix(strings.TrimSpace(content[end+1:]), ":") {
+				var s string
+				if json.Unmarshal([]byte(content[i:end+1]), &s) == nil && s == key {
+					name, value = content[i:end+1], end+1
+				}
+			}
+			i = end
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 && value >= 0 {
+				return "{" + name + content[value:i] + "}"
+			}
+		case c == ',' && depth == 1 && value >= 0:
+			return "{" + name + content[value:i] + "}"
+		}
+	}
+	return ""
+}
+
+// jsonStringEnd returns the index of the quote closing the json string starting at start
+func jsonStringEnd(content string, start int) int {
+	for i := start + 1; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// isTopLevelKey returns true if the line starts a new top level key or document
+func isTopLevelKey(line string) bool {
+	if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "...") {
+		return true
+	}
+	if len(line) == 0 {
+		return false
+	}
+	switch line[0] {
+	case ' ', '\t', '#', '-':
+		return false
+	}
+	return true
+}
+
+// missingDeclarations returns the declarations without a value in the data
+func missingDeclarations(declarations []models.Declaration, data map[string]any) []models.Declaration {
+	var missing []models.Declaration
+	for _, declaration := range declarations {
+		if _, ok := lookup(data, declaration.Name); !ok {
+			missing = append(missing, declaration)
+		}
+	}
+	return missing
+}
+
+// secretKeys returns the names of the secret declarations
+func secretKeys(declarations []models.Declaration) map[string]struct{} {
+	secrets := map[string]struct{}{}
+	for _, declaration := range declarations {
+		if declaration.Secret {
+			secrets[declaration.Name] = struct{}{}
+		}
+	}
+	return secrets
+}
+
+// promptDeclarations asks for the declared variables missing from the data.
+// Empty answers are left unset so the default is applied. Answers, except secrets, are written to
+// the save file when one is given.
+func (s *service) promptDeclarations(declarations []models.Declaration, data map[string]any, save string) error {
+	answers := map[string]any{}
+	for _, declaration := range declarations {
+		if _, ok := lookup(data, declaration.Name); ok {
+			continue
+		}
+		answer, err := s.promptDeclaration(declaration)
+		if err != nil {
+			return err
+		}
+		if answer == nil {
+			continue
+		}
+		set(data, declaration.Name, answer)
+		if !declaration.Secret {
+			set(answers, declaration.Name, answer)
+		}
+	}
+
+	if len(save) == 0 {
+		return nil
+	}
+	content, err := yaml.Marshal(answers)
+	if err != nil {
+		return err
+	}
+	return s.fs.WriteFile(save, content, 0600)
+}
+
+func (s *service) promptDeclaration(declaration models.Declaration) (any, error) {
+	message := declaration.Name + describe(declaration)
+	defaultValue := ""
+	if declaration.Default != nil {
+		defaultValue = fmt.Sprint(declaration.Default)
+	}
+
+	var answer string
+	var err error
+	switch {
+	case declaration.Secret:
+		answer, err = s.prompt.Secret(message)
+	case declaration.Type == models.TypeBool:
+		defaultBool, _ := declaration.Default.(bool)
+		return s.prompt.Confirm(message, defaultBool)
+	case declaration.Type == models.TypeList || declaration.Type == models.TypeMap:
+		// complex values are entered as yaml or json, an empty answer keeps the default
+		answer, err = s.prompt.Ask(message+" (yaml)", "")
+		if err != nil || len(answer) == 0 {
+			return nil, err
+		}
+		var value any
+		err = yaml.Unmarshal([]byte(answer), &value)
+		return value, err
+	case declaration.Validation != nil && len(declaration.Validation.Enum) > 0:
+		var choices []string
+		for _, e := range declaration.Validation.Enum {
+			choices = append(choices, fmt.Sprint(e))
+		}
+		answer, err = s.prompt.Select(message, choices, defaultValue)
+	default:
+		answer, err = s.prompt.Ask(message, defaultValue)
+	}
+	if err != nil || len(answer) == 0 {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// applyDeclarations sets default values, converts values to the declared type and validates the
+// data against the declarations. All validation errors are returned together.
+func applyDeclarations(declarations []models.Declaration, data map[string]any) error {
+	var errs []string
+	for _, declaration := range declarations {
+		name := fmt.Sprintf("variable '%s'", declaration.Name)
+		value, ok := lookup(data, declaration.Name)
+		if !ok {
+			if declaration.Default == nil {
+				if declaration.Required {
+					errs = append(errs, fmt.Sprintf("%s is required%s", name, describe(declaration)))
+				}
+				continue
+			}
+			// defaults are checked like any other value so a bad default fails the apply
+			name = fmt.Sprintf("variable '%s' default", declaration.Name)
+			value = declaration.Default
+		}
+
+		value, err := convert(declaration.Type, value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s %s", name, err))
+			continue
+		}
+
+		err = validate(declaration.Validation, value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s %s", name, err))
+			continue
+		}
+		set(data, declaration.Name, value)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid variables:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
+
+func describe(declaration models.Declaration) string {
+	if len(declaration.Description) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", declaration.Description)
+}
+
+// convert converts the value to the declared type. Strings are parsed for bool and int types.
+func convert(t string, value any) (any, error) {
+	switch t {
+	case "":
+		return value, nil
+	case models.TypeString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case map[string]any, []any:
+			return nil, fmt.Errorf("must be a string")
+		}
+		return fmt.Sprint(value), nil
+	case models.TypeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("must be a bool, found '%s'", v)
+			}
+			return b, nil
+		}
+		return nil, fmt.Errorf("must be a bool, found '%v'", value)
+	case models.TypeInt:
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case uint64:
+			return int(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return int(v), nil
+			}
+		case string:
+			i, err := strconv.Atoi(strings.TrimSpace(v))
+			if err == nil {
+				return i, nil
+			}
+		}
+		return nil, fmt.Errorf("must be an int, found '%v'", value)
+	case models.TypeList:
+		if v := reflect.ValueOf(value); v.Kind() == reflect.Slice {
+			return value, nil
+		}
+		return nil, fmt.Errorf("must be a list, found '%v'", value)
+	case models.TypeMap:
+		if v := reflect.ValueOf(value); v.Kind() == reflect.Map {
+			return value, nil
+		}
+		return nil, fmt.Errorf("must be a map, found '%v'", value)
+	}
+	return nil, fmt.Errorf("has unrecognized type '%s'. Expected one of string, bool, int, list or map", t)
+}
+
+func validate(validation *models.Validation, value any) error {
+	if validation == nil {
+		return nil
+	}
+
+	if len(validation.Regex) > 0 {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a string to match regex '%s'", validation.Regex)
+		}
+		reg, err := regexp.Compile(validation.Regex)
+		if err != nil {
+			return fmt.Errorf("has invalid regex '%s' : %w", validation.Regex, err)
+		}
+		if !reg.MatchString(s) {
+			return fmt.Errorf("value '%s' does not match regex '%s'", s, validation.Regex)
+		}
+	}
+
+	if len(validation.Enum) > 0 {
+		found := false
+		for _, e := range validation.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("value '%v' must be one of %v", value, validation.Enum)
+		}
+	}
+
+	if validation.Min == nil && validation.Max == nil {
+		return nil
+	}
+	measure, unit, err := measure(value)
+	if err != nil {
+		return err
+	}
+	if validation.Min != nil && measure < *validation.Min {
+		return fmt.Errorf("%s %v is less than the minimum %v", unit, measure, *validation.Min)
+	}
+	if validation.Max != nil && measure > *validation.Max {
+		return fmt.Errorf("%s %v is greater than the maximum %v", unit, measure, *validation.Max)
+	}
+	return nil
+}
+
+// measure returns the value of a number or the length of a string, list or map
+func measure(value any) (float64, string, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "value", nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), "value", nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), "value", nil
+	case reflect.String, reflect.Slice, reflect.Map:
+		return float64(v.Len()), "length", nil
+	}
+	return 0, "", fmt.Errorf("value '%v' can not be compared to a minimum or maximum", value)
+}
+
+// lookup returns the value at the dot separated key
+func lookup(data map[string]any, key string) (any, bool) {
+	var current any = data
+	for _, segment := range strings.Split(key, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[segment]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// set sets the value at the dot separated key creating maps as needed
+func set(data map[string]any, key string, value any) {
+	segments := strings.Split(key, ".")
+	current := data
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := current[segment].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			current[segment] = next
+		}
+		current = next
+	}
+	current[segments[len(segments)-1]] = value
+}
@@ -1,9 +1,87 @@
 package models
 
+import "text/template"
+
 // Caster is the top level struct representing a caster file
 type Caster struct {
+	// Tree renders every file and folder in the template directory into the target
+	Tree    bool     `yaml:"tree,omitempty" json:"tree" mapstructure:"tree"`
 	Files   []File   `yaml:"files,omitempty" json:"files" mapstructure:"files"`
 	Folders []Folder `yaml:"folders,omitempty" json:"folders" mapstructure:"folders"`
+	// Variables declares the input variables of the template
+	Variables []Declaration `yaml:"variables,omitempty" json:"variables" mapstructure:"variables"`
+	// Hooks are commands run before and after the template is applied
+	Hooks Hooks `yaml:"hooks,omitempty" json:"hooks" mapstructure:"hooks"`
+	// Includes are other templates applied as components of this template
+	Includes []Include `yaml:"includes,omitempty" json:"includes" mapstructure:"includes"`
+	// Extends is the parent template whose files, folders, hooks and variables are inherited
+	Extends string `yaml:"extends,omitempty" json:"extends" mapstructure:"extends"`
+}
+
+// Include applies another template into a subpath of the target
+type Include struct {
+	// Template is the template directory or file relative to the including caster file
+	Template string `yaml:"template,omitempty" json:"template" mapstructure:"template"`
+	// Target is the subpath of the target the template is applied to
+	Target string `yaml:"target,omitempty" json:"target" mapstructure:"target"`
+	// Variables are the variables passed to the included template
+	Variables map[string]any `yaml:"variables,omitempty" json:"variables" mapstructure:"variables"`
+}
+
+// Scope is the source file, data and functions of an included or extended template.
+// It is set on the files, folders and hooks of other templates when the caster file is interpolated.
+type Scope struct {
+	SourceFile string
+	Data       map[string]any
+	Funcs      template.FuncMap
+}
+
+// Hooks contains the commands run before and after the files and folders are written
+type Hooks struct {
+	PreApply  []Hook `yaml:"pre_apply,omitempty" json:"pre_apply" mapstructure:"pre_apply"`
+	PostApply []Hook `yaml:"post_apply,omitempty" json:"post_apply" mapstructure:"post_apply"`
+}
+
+// Hook is a shell command run in the target
+type Hook struct {
+	Command string `yaml:"command,omitempty" json:"command" mapstructure:"command"`
+	// Dir is the working directory relative to the target
+	Dir string            `yaml:"dir,omitempty" json:"dir" mapstructure:"dir"`
+	Env map[string]string `yaml:"env,omitempty" json:"env" mapstructure:"env"`
+	// When is a template expression like '.git' that must be true for the hook to run
+	When string `yaml:"when,omitempty" json:"when" mapstructure:"when"`
+	// Scope is set on hooks of included templates
+	Scope *Scope `yaml:"-" json:"-" mapstructure:"-"`
+}
+
+// Declaration declares an input variable of a template
+type Declaration struct {
+	Name        string `yaml:"name,omitempty" json:"name" mapstructure:"name"`
+	Type        string `yaml:"type,omitempty" json:"type" mapstructure:"type"`
+	Default     any    `yaml:"default,omitempty" json:"default" mapstructure:"default"`
+	Description string `yaml:"description,omitempty" json:"description" mapstructure:"description"`
+	Required    bool   `yaml:"required,omitempty" json:"required" mapstructure:"required"`
+	// Secret hides the answer when prompting and keeps it out of saved answers
+	Secret     bool        `yaml:"secret,omitempty" json:"secret" mapstructure:"secret"`
+	Validation *Validation `yaml:"validation,omitempty" json:"validation" mapstructure:"validation"`
+}
+
+// The types of a declared variable
+const (
+	TypeString = "string"
+	TypeBool   = "bool"
+	TypeInt    = "int"
+	TypeList   = "list"
+	TypeMap    = "map"
+)
+
+// Validation constrains the value of a declared variable.
+// Min and Max limit numbers by value and strings, lists and maps by length.
+type Validation struct {
+	Regex string   `yaml:"regex,omitempty" json:"regex" mapstructure:"regex"`
+	Enum  []any    `yaml:"enum,omitempty" json:"enum" mapstructure:"enum"`
+	Min   *float64 `yaml:"min,omitempty" json:"min" mapstructure:"min"`
+	Max   *float64 `yaml:"max,omitempty" json:"max" mapstructure:"max"`
 }
 
 // File represents a file in the hierarchy
@@ -11,13 +89,56 @@ type File struct {
 	Name    string `yaml:"name,omitempty" json:"name" mapstructure:"name"`
 	Content string `yaml:"content,omitempty" json:"content" mapstructure:"content"`
 	Ref     string `yaml:"ref,omitempty" json:"ref" mapstructure:"ref"`
+	// OnConflict overrides the conflict policy when the file already exists in the target
+	OnConflict string `yaml:"on_conflict,omitempty" json:"on_conflict" mapstructure:"on_conflict"`
+	// Mode is the octal permission mode of the file, for example "0755"
+	Mode string `yaml:"mode,omitempty" json:"mode" mapstructure:"mode"`
+	// Link creates the file as a symbolic link to the path relative to the file
+	Link string `yaml:"link,omitempty" json:"link" mapstructure:"link"`
+	// Template renders the ref file with the data used to render the caster file
+	Template bool `yaml:"template,omitempty" json:"template" mapstructure:"template"`
+	// Data overrides keys of the data used to render the ref file
+	Data map[string]any `yaml:"data,omitempty" json:"data" mapstructure:"data"`
+	// When is a template expression like '.license' that must be true for the file to be written
+	When string `yaml:"when,omitempty" json:"when" mapstructure:"when"`
+	// Foreach is a template expression like '.regions' that returns a list. The file is repeated for each item.
+	Foreach string `yaml:"foreach,omitempty" json:"foreach" mapstructure:"foreach"`
+	// As is the name of the variable holding the current item. Defaults to 'item'.
+	As string `yaml:"as,omitempty" json:"as" mapstructure:"as"`
+	// Delete removes the file with the same name inherited from the parent template
+	Delete bool `yaml:"delete,omitempty" json:"delete" mapstructure:"delete"`
+	// Scope is set on files inherited from a parent template
+	Scope *Scope `yaml:"-" json:"-" mapstructure:"-"`
 }
 
+// DefaultForeachAlias is the name of the variable holding the current foreach item
+const DefaultForeachAlias = "item"
+
 // Folder represents a folder in the hierachy
 type Folder struct {
 	Name    string   `yaml:"name,omitempty" json:"name" mapstructure:"name"`
 	Files   []File   `yaml:"files,omitempty" json:"files" mapstructure:"files"`
 	Folders []Folder `yaml:"folders,omitempty" json:"folders" mapstructure:"folders"`
+	// Mode is the octal permission mode of the folder, for example "0755"
+	Mode string `yaml:"mode,omitempty" json:"mode" mapstructure:"mode"`
+	// Ref copies the folder at the path relative to the template into the folder
+	Ref string `yaml:"ref,omitempty" json:"ref" mapstructure:"ref"`
+	// Include filters the files copied from ref with glob patterns
+	Include []string `yaml:"include,omitempty" json:"include" mapstructure:"include"`
+	// Exclude removes files and folders copied from ref with glob patterns
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude" mapstructure:"exclude"`
+	// Template renders the names and content of the files and folders copied from ref
+	Template bool `yaml:"template,omitempty" json:"template" mapstructure:"template"`
+	// When is a template expression like '.docker' that must be true for the folder to be written
+	When string `yaml:"when,omitempty" json:"when" mapstructure:"when"`
+	// Foreach is a template expression like '.regions' that returns a list. The folder is repeated for each item.
+	Foreach string `yaml:"foreach,omitempty" json:"foreach" mapstructure:"foreach"`
+	// As is the name of the variable holding the current item. Defaults to 'item'.
+	As string `yaml:"as,omitempty" json:"as" mapstructure:"as"`
+	// Delete removes the folder with the same name inherited from the parent template
+	Delete bool `yaml:"delete,omitempty" json:"delete" mapstructure:"delete"`
+	// Scope is set on folders of included and extended templates
+	Scope *Scope `yaml:"-" json:"-" mapstructure:"-"`
 }
 
 // Variable represents a variable file, key value or environment variable
@@ -26,4 +147,15 @@ type Variable struct {
 	Key   string `yaml:"omitempty"`
 	Value string `yaml:"omitempty"`
 	Env   string `yaml:"omitempty"`
+	// Format is the format used to decode the value or file. An empty format keeps the value as a string
+	// and detects the format of a file from the extension.
+	Format string `yaml:"omitempty"`
 }
+
+const (
+	FormatYaml       = "yaml"
+	FormatJson       = "json"
+	FormatToml       = "toml"
+	FormatEnv        = "env"
+	FormatProperties = "properties"
+)
@@ -0,0 +1,149 @@
+package prompt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/patrickhuber/go-xplat/console"
+	"golang.org/x/term"
+)
+
+// Service asks the user questions on the console
+type Service interface {
+	Confirm(message string, defaultValue bool) (bool, error)
+	Ask(message string, defaultValue string) (string, error)
+	Select(message string, choices []string, defaultValue string) (string, error)
+	Secret(message string) (string, error)
+	// Interactive returns false when the console input is a file that is not a terminal, like a pipe or a redirect
+	Interactive() bool
+}
+
+// NewService creates a new instance of the prompt service
+func NewService(console console.Console) Service {
+	return &service{
+		console: console,
+	}
+}
+
+type service struct {
+	console console.Console
+	reader  *bufio.Reader
+}
+
+// Confirm asks a yes or no question. An empty answer returns the default value.
+func (s *service) Confirm(message string, defaultValue bool) (bool, error) {
+	options := "y/N"
+	if defaultValue {
+		options = "Y/n"
+	}
+	for {
+		answer, err := s.ask(fmt.Sprintf("%s [%s]: ", message, options))
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return defaultValue, nil
+		case "y", "yes", "true":
+			return true, nil
+		case "n", "no", "false":
+			return false, nil
+		}
+		fmt.Fprintf(s.console.Error(), "unrecognized answer '%s'\n", answer)
+	}
+}
+
+// Ask asks for a single line answer. An empty answer returns the default value.
+func (s *service) Ask(message string, defaultValue string) (string, error) {
+	question := fmt.Sprintf("%s: ", message)
+	if len(defaultValue) > 0 {
+		question = fmt.Sprintf("%s [%s]: ", message, defaultValue)
+	}
+	answer, err := s.ask(question)
+	if err != nil {
+		return "", err
+	}
+	if len(answer) == 0 {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+// Select asks for one of the choices by number or by value. An empty answer returns the default value.
+func (s *service) Select(message string, choices []string, defaultValue string) (string, error) {
+	_, err := fmt.Fprintln(s.console.Error(), message)
+	if err != nil {
+		return "", err
+	}
+	for i, choice := range choices {
+		fmt.Fprintf(s.console.Error(), "  %d) %s\n", i+1, choice)
+	}
+	for {
+		answer, err := s.Ask("choose", defaultValue)
+		if err != nil {
+			return "", err
+		}
+		if len(answer) == 0 {
+			return "", nil
+		}
+		if i, err := strconv.Atoi(answer); err == nil && i > 0 && i <= len(choices) {
+			return choices[i-1], nil
+		}
+		for _, choice := range choices {
+			if choice == answer {
+				return choice, nil
+			}
+		}
+		fmt.Fprintf(s.console.Error(), "unrecognized choice '%s'\n", answer)
+	}
+}
+
+// Interactive returns false when the console input is a file that is not a terminal. Other readers
+// like in memory consoles are answered by the caller and are interactive.
+func (s *service) Interactive() bool {
+	file, ok := s.console.In().(*os.File)
+	return !ok || term.IsTerminal(int(file.Fd()))
+}
+
+// Secret asks for an answer without echoing the input when the console input is a terminal
+func (s *service) Secret(message string) (string, error) {
+	file, ok := s.console.In().(*os.File)
+	if !ok || !term.IsTerminal(int(file.Fd())) {
+		return s.ask(fmt.Sprintf("%s: ", message))
+	}
+	_, err := fmt.Fprintf(s.console.Error(), "%s: ", message)
+	if err != nil {
+		return "", err
+	}
+	answer, err := term.ReadPassword(int(file.Fd()))
+	fmt.Fprintln(s.console.Error())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(answer)), nil
+}
+
+// ask writes the question and reads a single line answer.
+// The end of the input is treated as an empty answer.
+func (s *service) ask(question string) (string, error) {
+	_, err := fmt.Fprint(s.console.Error(), question)
+	if err != nil {
+		return "", err
+	}
+	if s.reader == nil {
+		s.reader = bufio.NewReader(s.console.In())
+	}
+	line, err := s.reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if errors.Is(err, io.EOF) && len(line) == 0 {
+		fmt.Fprintln(s.console.Error())
+	}
+	return strings.TrimSpace(line), nil
+}
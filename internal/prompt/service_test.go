@@ -0,0 +1,121 @@
+package prompt_test
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/patrickhuber/caster/internal/prompt"
+	"github.com/patrickhuber/go-xplat/console"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfirm(t *testing.T) {
+	type test struct {
+		name         string
+		input        string
+		defaultValue bool
+		want         bool
+	}
+	tests := []test{
+		{"yes", "y\n", false, true},
+		{"no", "no\n", true, false},
+		{"default", "\n", true, true},
+		{"eof", "", false, false},
+		{"retry", "maybe\nyes\n", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			con := console.NewMemory()
+			con.InBuffer().WriteString(test.input)
+			svc := prompt.NewService(con)
+			ok, err := svc.Confirm("continue?", test.defaultValue)
+			require.NoError(t, err)
+			require.Equal(t, test.want, ok)
+		})
+	}
+}
+
+func TestAsk(t *testing.T) {
+	type test struct {
+		name         string
+		input        string
+		defaultValue string
+		want         string
+	}
+	tests := []test{
+		{"answer", "value\n", "", "value"},
+		{"default", "\n", "default", "default"},
+		{"eof", "", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			con := console.NewMemory()
+			con.InBuffer().WriteString(test.input)
+			svc := prompt.NewService(con)
+			answer, err := svc.Ask("name", test.defaultValue)
+			require.NoError(t, err)
+			require.Equal(t, test.want, answer)
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	type test struct {
+		name         string
+		input        string
+		defaultValue string
+		want         string
+	}
+	tests := []test{
+		{"number", "2\n", "", "apache"},
+		{"value", "mit\n", "", "mit"},
+		{"default", "\n", "apache", "apache"},
+		{"retry", "3\ngpl\nmit\n", "", "mit"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			con := console.NewMemory()
+			con.InBuffer().WriteString(test.input)
+			svc := prompt.NewService(con)
+			answer, err := svc.Select("license", []string{"mit", "apache"}, test.defaultValue)
+			require.NoError(t, err)
+			require.Equal(t, test.want, answer)
+		})
+	}
+}
+
+func TestSecret(t *testing.T) {
+	con := console.NewMemory()
+	con.InBuffer().WriteString("password\n")
+	svc := prompt.NewService(con)
+	answer, err := svc.Secret("token")
+	require.NoError(t, err)
+	require.Equal(t, "password", answer)
+}
+
+func TestInteractive(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		svc := prompt.NewService(console.NewMemory())
+		require.True(t, svc.Interactive())
+	})
+	t.Run("pipe", func(t *testing.T) {
+		reader, writer, err := os.Pipe()
+		require.NoError(t, err)
+		defer reader.Close()
+		defer writer.Close()
+
+		svc := prompt.NewService(&pipeConsole{Memory: console.NewMemory(), in: reader})
+		require.False(t, svc.Interactive())
+	})
+}
+
+// pipeConsole reads the input from a pipe like 'sleep 6 | caster apply'
+type pipeConsole struct {
+	console.Memory
+	in *os.File
+}
+
+func (c *pipeConsole) In() io.Reader {
+	return c.in
+}
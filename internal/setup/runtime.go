@@ -1,12 +1,20 @@
 package setup
 
 import (
+	"github.com/patrickhuber/caster/internal/archive"
+	"github.com/patrickhuber/caster/internal/execute"
+	"github.com/patrickhuber/caster/internal/git"
 	"github.com/patrickhuber/caster/internal/initialize"
 	"github.com/patrickhuber/caster/internal/interpolate"
+	"github.com/patrickhuber/caster/internal/prompt"
+	"github.com/patrickhuber/caster/internal/symlink"
 	"github.com/patrickhuber/go-xplat/filepath"
 	"github.com/patrickhuber/go-xplat/fs"
+	"github.com/patrickhuber/go-xplat/os"
 
+	"github.com/patrickhuber/caster/internal/cache"
 	"github.com/patrickhuber/caster/internal/cast"
+	"github.com/patrickhuber/caster/internal/catalog"
 	"github.com/patrickhuber/go-di"
 	"github.com/patrickhuber/go-xplat/env"
 
@@ -17,13 +25,21 @@ func New() Setup {
 	container := di.NewContainer()
 	container.RegisterConstructor(env.NewOS)
 	container.RegisterConstructor(fs.NewOS)
+	container.RegisterConstructor(os.New)
 	container.RegisterConstructor(func() *filepath.Processor {
 		// options cause issues with constructor registration
 		return filepath.NewProcessor()
 	})
 	container.RegisterConstructor(cast.NewService)
 	container.RegisterConstructor(interpolate.NewService)
+	container.RegisterConstructor(cache.NewService)
+	container.RegisterConstructor(git.NewService)
+	container.RegisterConstructor(archive.NewService)
 	container.RegisterConstructor(initialize.NewService)
+	container.RegisterConstructor(catalog.NewService)
+	container.RegisterConstructor(prompt.NewService)
+	container.RegisterConstructor(symlink.NewOS)
+	container.RegisterConstructor(execute.NewOS)
 	container.RegisterConstructor(console.NewOS)
 	return &runtime{
 		container: container,
@@ -1,9 +1,16 @@
 package setup
 
 import (
+	"github.com/patrickhuber/caster/internal/archive"
+	"github.com/patrickhuber/caster/internal/cache"
 	"github.com/patrickhuber/caster/internal/cast"
+	"github.com/patrickhuber/caster/internal/catalog"
+	"github.com/patrickhuber/caster/internal/execute"
+	"github.com/patrickhuber/caster/internal/git"
 	"github.com/patrickhuber/caster/internal/initialize"
 	"github.com/patrickhuber/caster/internal/interpolate"
+	"github.com/patrickhuber/caster/internal/prompt"
+	"github.com/patrickhuber/caster/internal/symlink"
 	"github.com/patrickhuber/go-di"
 	"github.com/patrickhuber/go-xplat/console"
 	"github.com/patrickhuber/go-xplat/env"
@@ -28,7 +35,16 @@ func NewTest() Setup {
 	})
 	container.RegisterConstructor(cast.NewService)
 	container.RegisterConstructor(interpolate.NewService)
+	container.RegisterConstructor(cache.NewService)
+	container.RegisterConstructor(git.NewService)
+	container.RegisterConstructor(archive.NewService)
 	container.RegisterConstructor(initialize.NewService)
+	container.RegisterConstructor(catalog.NewService)
+	container.RegisterConstructor(prompt.NewService)
+	container.RegisterConstructor(symlink.NewMemory)
+	container.RegisterConstructor(func() execute.Executor {
+		return execute.NewMemory()
+	})
 	container.RegisterConstructor(func() console.Console {
 		return console.NewMemory()
 	})
@@ -0,0 +1,68 @@
+// Package symlink provides creation of symbolic links through an interface
+package symlink
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+
+	afs "github.com/patrickhuber/go-xplat/fs"
+)
+
+// Linker creates and reads symbolic links
+type Linker interface {
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+}
+
+// NewOS creates a linker for the operating system file system
+func NewOS() Linker {
+	return &osLinker{}
+}
+
+type osLinker struct{}
+
+func (*osLinker) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (*osLinker) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// NewMemory creates a linker that stores links as files with the symlink mode in the file system
+func NewMemory(fs afs.FS) Linker {
+	return &memory{
+		fs: fs,
+	}
+}
+
+type memory struct {
+	fs afs.FS
+}
+
+func (m *memory) Symlink(oldname, newname string) error {
+	ok, err := m.fs.Exists(newname)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	return m.fs.WriteFile(newname, []byte(oldname), fs.ModeSymlink|fs.ModePerm)
+}
+
+func (m *memory) Readlink(name string) (string, error) {
+	info, err := m.fs.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fmt.Errorf("not a symbolic link")}
+	}
+	content, err := m.fs.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
@@ -0,0 +1,26 @@
+package symlink_test
+
+import (
+	"testing"
+
+	"github.com/patrickhuber/caster/internal/symlink"
+	afs "github.com/patrickhuber/go-xplat/fs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	fs := afs.NewMemory()
+	require.NoError(t, fs.WriteFile("/file.txt", []byte("file"), 0666))
+
+	linker := symlink.NewMemory(fs)
+	require.NoError(t, linker.Symlink("file.txt", "/link.txt"))
+
+	target, err := linker.Readlink("/link.txt")
+	require.NoError(t, err)
+	require.Equal(t, "file.txt", target)
+
+	require.Error(t, linker.Symlink("other.txt", "/link.txt"))
+
+	_, err = linker.Readlink("/file.txt")
+	require.Error(t, err)
+}